@@ -2,13 +2,15 @@ package FancyRPC
 
 import (
 	"FancyRPC/codec"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"log"
 	"net"
+	"reflect"
 	"sync"
+	"time"
 )
 
 type Call struct {
@@ -19,18 +21,30 @@ type Call struct {
 	Error         error
 	Done          chan *Call
 	ServiceMethod string
+
+	client *Client
+	start  time.Time
+	ctx    context.Context
+	span   *Span
+	stream *ClientStream // 流式调用时不为 nil
 }
 
 // 为了支持异步调用，Call 结构体中添加了一个字段 Done，
 // Done 的类型是 chan *Call，当调用结束时，会调用 call.done() 通知调用方。
 func (call *Call) done() {
+	if call.client != nil {
+		call.client.finishCall(call)
+	}
+	if call.stream != nil {
+		call.stream.finish(call.Error)
+	}
 	call.Done <- call
 }
 
 type Client struct {
 	cc      codec.Codec //cc 是消息的编解码器，和服务端类似，用来序列化将要发送出去的请求，以及反序列化接收到的响应。
 	opt     *Option
-	sending sync.Mutex   //sending 是一个互斥锁，和服务端类似，为了保证请求的有序发送，即防止出现多个请求报文混淆。
+	sending *sync.Mutex  //sending 是一个互斥锁，和服务端类似，为了保证请求的有序发送，即防止出现多个请求报文混淆。
 	header  codec.Header //header 是每个请求的消息头，header 只有在请求发送时才需要，
 	// 而请求发送是互斥的，因此每个客户端只需要一个，声明在 Client 结构体中可以复用。
 	mu       sync.Mutex //为了保护pending map
@@ -38,15 +52,40 @@ type Client struct {
 	closing  bool
 	shutdown bool
 	seq      uint64
+	logger   Logger
+	metrics  *clientMetrics
+	window   int  // 流控窗口，见 Option.StreamWindow
+	reverse  bool // 服务端用来发起反向调用的 Client，发出的帧都带 Header.Reverse
+
+	// 处理服务端发起的反向调用，见 Register
+	server   *Server
+	sc       *serverConn
+	handlers sync.WaitGroup // receive 退出前等待所有处理函数返回
+	received chan struct{}  // receive 退出后关闭，newCaller 创建的 Client 为 nil
+
+	hb *heartbeat // 未开启心跳时为 nil
 }
 
 var _ io.Closer = (*Client)(nil)
 
-var ErrShutdown = errors.New("connection is shut down")
+// ClientConn 发起调用的一方，*Client 和 *Pool 都实现了它，生成的类型化客户端通过它调用
+type ClientConn interface {
+	Call(serviceMethod string, args, reply interface{}) error
+	CallContext(ctx context.Context, serviceMethod string, args, reply interface{}) error
+	Stream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error)
+	OpenStream(ctx context.Context, serviceMethod string, reply interface{}) (*ClientStream, error)
+}
+
+var (
+	_ ClientConn = (*Client)(nil)
+	_ ClientConn = (*Pool)(nil)
+)
+
+var ErrShutdown error = NewError(Unavailable, "connection is shut down")
 
 func (client *Client) Close() error {
-	//client.mu.Lock()
-	//defer client.mu.Unlock()
+	client.mu.Lock()
+	defer client.mu.Unlock()
 	if client.closing {
 		return ErrShutdown
 	}
@@ -56,8 +95,8 @@ func (client *Client) Close() error {
 }
 
 func (client *Client) IsAvailable() bool {
-	//client.mu.Lock()
-	//defer client.mu.Unlock()
+	client.mu.Lock()
+	defer client.mu.Unlock()
 
 	return !client.shutdown && !client.closing
 
@@ -85,6 +124,20 @@ func (client *Client) removeCall(seq uint64) *Call {
 	return call // 这里为什么还返回call，这是removeCall方法
 }
 
+// getCall 与 removeCall 类似但不删除，流中间的帧使用
+func (client *Client) getCall(seq uint64) *Call {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return client.pending[seq]
+}
+
+// pendingCalls 等待响应的调用数，包括进行中的流，Pool 据此选择连接
+func (client *Client) pendingCalls() int {
+	client.mu.Lock()
+	defer client.mu.Unlock()
+	return len(client.pending)
+}
+
 func (client *Client) terminateCalls(err error) {
 	client.sending.Lock()
 	defer client.sending.Unlock()
@@ -92,72 +145,187 @@ func (client *Client) terminateCalls(err error) {
 	defer client.mu.Unlock()
 	client.shutdown = true
 
-	for _, call := range client.pending {
+	// 从 pending 中删除，之后 ctx 取消等路径 removeCall 拿不到调用，不会重复 done
+	for seq, call := range client.pending {
+		delete(client.pending, seq)
 		call.Error = err
 		call.done()
 	}
 }
 
+// finishCall 在调用结束、通知调用方之前执行，负责记录指标
+func (client *Client) finishCall(call *Call) {
+	if client.metrics != nil {
+		client.metrics.observe(call)
+	}
+	if call.span != nil {
+		call.span.End(call.Error)
+	}
+}
+
 func (client *Client) receive() {
 	var err error
 	for err == nil {
 		var h codec.Header
 		if err = client.cc.ReadHeader(&h); err != nil {
-			log.Printf("receive ReadHeader err : %s", err)
 			break
 		}
-		call := client.removeCall(h.Seq)
-		switch {
-		case call == nil:
-			err = client.cc.ReadBody(nil)
-
-		case h.Error != "":
-			call.Error = fmt.Errorf(h.Error)
-			err = client.cc.ReadBody(nil)
-			call.done()
-		default:
-			err = client.cc.ReadBody(call.Rely)
-			if err != nil {
-				call.Error = errors.New("reading body" + err.Error())
-			}
-			call.done()
+		var handled bool
+		if handled, err = handleKeepalive(client.cc, client.sending, client.hb, &h); handled {
+			continue
+		}
+		if h.Reverse {
+			// 服务端发起的反向调用，按服务端的方式处理
+			err = client.server.serveFrame(client.sc, &h, &client.handlers)
+			continue
+		}
+		err = client.dispatch(&h)
+	}
+	client.hb.close()
+	err = client.hb.err(err)
+	if err != io.EOF {
+		client.logger.Log(LevelError, "rpc client: receive error", F(FieldError, err))
+	}
+	client.sc.cancel()
+	client.sc.closeStreams()
+	client.terminateCalls(unavailable(err))
+	// 处理函数的 ctx 已经取消，等它们返回，之后不会再有协程使用这个连接
+	client.handlers.Wait()
+	close(client.received)
+}
+
+// dispatch 处理本端发起的调用的一个响应帧，返回错误时连接无法继续使用
+func (client *Client) dispatch(h *codec.Header) (err error) {
+	switch h.Type {
+	case codec.FrameData:
+		return client.receiveData(h)
+	case codec.FrameWindow:
+		if call := client.getCall(h.Seq); call != nil && call.stream != nil {
+			call.stream.credit.add(int(h.Window))
+		}
+		return client.cc.ReadBody(nil)
+	}
+	call := client.removeCall(h.Seq)
+	switch {
+	case call == nil:
+		err = client.cc.ReadBody(nil)
+
+	case h.Error != "" || h.Code != 0:
+		call.Error = errorFromHeader(h)
+		err = client.cc.ReadBody(nil)
+		call.done()
+	default:
+		err = client.cc.ReadBody(call.Rely)
+		if errors.Is(err, codec.ErrMessageTooLarge) {
+			call.Error = messageTooLarge("rpc client: reading body", err)
+		} else if err != nil {
+			call.Error = errors.New("reading body" + err.Error())
 		}
+		call.done()
+	}
+	if bodySkipped(err) {
+		return nil
 	}
-	log.Printf(" (client *Client) receive() ok !")
-	client.terminateCalls(err)
+	return err
+}
+
+// receiveData 读取流中的一条消息并交给对应的 ClientStream，找不到对应的流时丢弃
+func (client *Client) receiveData(h *codec.Header) error {
+	call := client.getCall(h.Seq)
+	if call == nil || call.stream == nil {
+		return client.cc.ReadBody(nil)
+	}
+	v := reflect.New(call.stream.replyType)
+	if err := client.cc.ReadBody(v.Interface()); err != nil {
+		if bodySkipped(err) {
+			call.stream.cancel(messageTooLarge("rpc client: reading stream message", err))
+			return nil
+		}
+		return err
+	}
+	if !call.stream.recv.push(v) {
+		client.logger.Log(LevelWarn, "rpc client: server exceeded stream window",
+			F(FieldSeq, h.Seq), F(FieldServiceMethod, call.ServiceMethod))
+		call.stream.cancel(errFlowControl)
+	}
+	return nil
 }
 
 func NewClient(conn net.Conn, opt *Option) (*Client, error) {
 
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	log.Printf("client.go.NewClient %s \n", opt.CodecType)
-	if f == nil {
-		err := fmt.Errorf("invalid codec type %s", opt.CodecType)
-		log.Println("rpc client :codec error:", err)
+	lg := opt.Logger
+	if lg == nil {
+		lg = NopLogger
+	}
+	var target string
+	if conn.RemoteAddr() != nil {
+		target = conn.RemoteAddr().String()
+		lg = lg.With(F(FieldRemoteAddr, target))
+	}
+	var cm *clientMetrics
+	if opt.Metrics != nil {
+		cm = newClientMetrics(opt.Metrics, target)
+		conn = &countingNetConn{Conn: conn, read: cm.readBytes, written: cm.writeBytes}
+	}
+	f, err := codecFunc(opt)
+	if err != nil {
+		lg.Log(LevelError, "rpc client: codec error", F(FieldError, err))
 		return nil, err
 
 	}
 	if err := json.NewEncoder(conn).Encode(opt); err != nil {
-		log.Println("rpc client options error: ", err)
+		lg.Log(LevelError, "rpc client: options error", F(FieldError, err))
 		_ = conn.Close()
 		return nil, err
 	}
-	log.Println("NewClient ok!")
-	return newClientCodec(f(conn), opt), nil
+	client := newClientCodec(f(conn), opt, lg)
+	client.metrics = cm
+	return client, nil
 }
 
-func newClientCodec(cc codec.Codec, opt *Option) *Client {
-	var client = &Client{
+func newClientCodec(cc codec.Codec, opt *Option, lg Logger) *Client {
+	client := newCaller(cc, opt, lg, new(sync.Mutex), false)
+	srvOpts := []ServerOption{WithLogger(lg)}
+	if opt.Tracer != nil {
+		srvOpts = append(srvOpts, WithTracer(opt.Tracer))
+	}
+	client.server = NewServer(srvOpts...)
+	limitSizes(cc, opt.MaxHeaderSize, opt.MaxBodySize)
+	client.sc = newServerConn(cc, client.sending, lg, client.window, client)
+	client.hb = startHeartbeat(cc, client.sending, lg, opt.HeartbeatInterval, opt.HeartbeatTimeout)
+	client.received = make(chan struct{})
+	go client.receive()
+	return client
+}
+
+// newCaller 创建不带接收协程的 Client，由调用方的读循环把响应交给 dispatch。
+// 服务端每个连接上的反向调用都通过它发起，与连接共用 sending。
+func newCaller(cc codec.Codec, opt *Option, lg Logger, sending *sync.Mutex, reverse bool) *Client {
+	client := &Client{
 		seq:     1,
 		cc:      cc,
 		opt:     opt,
+		sending: sending,
 		pending: make(map[uint64]*Call),
+		logger:  lg,
+		window:  opt.StreamWindow,
+		reverse: reverse,
+	}
+	if client.window <= 0 {
+		client.window = DefaultStreamWindow
 	}
-	go client.receive()
-	log.Printf("newClientCodec recevie !")
 	return client
 }
 
+// Register 在客户端注册接收者，服务端的处理函数可以通过 CallerFromContext 反向调用它的方法。
+// 规则与 Server.Register 相同。
+func (client *Client) Register(rcvr interface{}) error {
+	if client.server == nil {
+		return errors.New("rpc client: cannot register receivers on a caller")
+	}
+	return client.server.Register(rcvr)
+}
+
 func parseOptions(opts ...*Option) (*Option, error) {
 	if len(opts) == 0 || opts[0] == nil {
 		return DefaultOption, nil
@@ -171,8 +339,6 @@ func parseOptions(opts ...*Option) (*Option, error) {
 	if opt.CodecType == "" {
 		opt.CodecType = DefaultOption.CodecType
 	}
-	log.Println("11111111111111111111111111111111111")
-	log.Printf("parseOptions %s\n", opt.CodecType)
 	return opt, nil
 }
 
@@ -191,8 +357,6 @@ func Dial(network, address string, opts ...*Option) (client *Client, err error)
 			_ = conn.Close()
 		}
 	}()
-	log.Printf("Dial: %v", opt)
-
 	return NewClient(conn, opt)
 }
 
@@ -200,6 +364,14 @@ func (client *Client) send(call *Call) {
 	client.sending.Lock()
 	defer client.sending.Unlock()
 
+	var traceParent string
+	if tracer := client.opt.Tracer; tracer != nil {
+		_, call.span = tracer.StartSpan(call.ctx, call.ServiceMethod, SpanKindClient)
+		call.span.SetAttribute("rpc.system", "fancyrpc")
+		call.span.SetAttribute("rpc.method", call.ServiceMethod)
+		traceParent = call.span.SpanContext().TraceParent()
+	}
+
 	seq, err := client.registerCall(call)
 	if err != nil {
 		call.Error = err
@@ -207,11 +379,17 @@ func (client *Client) send(call *Call) {
 		return
 	}
 
-	client.header.ServerMethod = call.ServiceMethod
+	client.header.ServiceMethod = call.ServiceMethod
 	client.header.Seq = seq
 	client.header.Error = ""
+	client.header.TraceParent = traceParent
+	client.header.Type = codec.FrameCall
+	client.header.Reverse = client.reverse
+	client.header.Metadata = outgoingMetadata(call.ctx)
 
 	if err := client.cc.Write(&client.header, call.Args); err != nil {
+		client.logger.Log(LevelError, "rpc client: write request error",
+			F(FieldSeq, seq), F(FieldServiceMethod, call.ServiceMethod), F(FieldError, err))
 		call := client.removeCall(seq)
 		if call != nil {
 			call.Error = err
@@ -223,29 +401,89 @@ func (client *Client) send(call *Call) {
 
 // Go 暴露给客户但调用,异步接口
 func (client *Client) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	return client.goContext(context.Background(), serviceMethod, args, reply, done)
+}
+
+// Notify 单向调用：服务端执行方法但不回复，客户端也不登记等待中的 Call。
+// 返回的错误只表示请求没能发送出去，服务端的错误记录在服务端的日志和指标中。
+func (client *Client) Notify(serviceMethod string, args interface{}) error {
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	client.mu.Lock()
+	closed := client.closing || client.shutdown
+	client.mu.Unlock()
+	if closed {
+		return ErrShutdown
+	}
 
+	h := codec.Header{ServiceMethod: serviceMethod, Type: codec.FrameNotify, Reverse: client.reverse}
+	var span *Span
+	if tracer := client.opt.Tracer; tracer != nil {
+		_, span = tracer.StartSpan(context.Background(), serviceMethod, SpanKindClient)
+		span.SetAttribute("rpc.system", "fancyrpc")
+		span.SetAttribute("rpc.method", serviceMethod)
+		h.TraceParent = span.SpanContext().TraceParent()
+	}
+	err := client.cc.Write(&h, args)
+	if span != nil {
+		span.End(err)
+	}
+	if cm := client.metrics; cm != nil {
+		cm.requests.with(cm.target, serviceMethod).Inc()
+		if err != nil {
+			cm.errors.with(cm.target, serviceMethod).Inc()
+		}
+	}
+	if err != nil {
+		client.logger.Log(LevelError, "rpc client: write notification error",
+			F(FieldServiceMethod, serviceMethod), F(FieldError, err))
+	}
+	return err
+}
+
+func (client *Client) goContext(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	call := client.newCall(ctx, serviceMethod, args, reply, done)
+	client.send(call)
+	return call
+}
+
+func (client *Client) newCall(ctx context.Context, serviceMethod string, args, reply interface{}, done chan *Call) *Call {
 	if done == nil {
 		done = make(chan *Call, 10)
-		log.Println("rpc client done channel is buffered")
-
 	} else if cap(done) == 0 {
-		log.Println("rpc client done channel is unbuffered")
-
+		client.logger.Log(LevelWarn, "rpc client: done channel is unbuffered", F(FieldServiceMethod, serviceMethod))
 	}
 	call := &Call{
 		ServiceMethod: serviceMethod,
 		Args:          args,
 		Rely:          reply,
 		Done:          done,
+		client:        client,
+		start:         time.Now(),
+		ctx:           ctx,
+	}
+	if client.metrics != nil {
+		client.metrics.inFlight.Inc()
 	}
-	client.send(call)
 	return call
-
 }
 
 // Call 暴露给客户但调用,同步接口
 func (client *Client) Call(serviceMethod string, args, reply interface{}) error {
-	log.Printf("client *Client) Call process")
-	call := <-client.Go(serviceMethod, args, reply, make(chan *Call, 1)).Done
-	return call.Error
+	return client.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext 与 Call 相同，ctx 用于超时控制和取消，同时携带追踪用的父 span
+func (client *Client) CallContext(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	call := client.goContext(ctx, serviceMethod, args, reply, make(chan *Call, 1))
+	select {
+	case <-ctx.Done():
+		if c := client.removeCall(call.Seq); c != nil {
+			c.Error = fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+			c.done()
+		}
+		return fmt.Errorf("rpc client: call failed: %w", ctx.Err())
+	case call := <-call.Done:
+		return call.Error
+	}
 }
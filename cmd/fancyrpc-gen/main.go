@@ -0,0 +1,426 @@
+// fancyrpc-gen 为服务类型生成类型化的客户端和注册函数，方法名、参数和回复的类型在编译期检查。
+// 在服务类型所在的包中加上
+//
+//	//go:generate go run FancyRPC/cmd/fancyrpc-gen -type Foo
+//
+// 对于 Foo 上每个满足 Server.Register 规则的方法，生成 FooClient 上的同名方法，例如
+// FooClient.Sum(ctx, Args) (int, error)，以及 RegisterFoo(server, *Foo)。
+// 服务端流方法返回 *FancyRPC.ClientStream，客户端流和双向流方法通过 OpenStream 打开。
+package main
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+const (
+	fancyPath     = "FancyRPC"
+	defaultOutput = "fancyrpc_gen.go" // 位于包的目录中
+)
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("fancyrpc-gen: ")
+	typeNames := flag.String("type", "", "comma-separated list of service type names; empty means every type with RPC methods")
+	output := flag.String("output", "", "output file name; default is "+defaultOutput+" in the package directory")
+	flag.Usage = func() {
+		_, _ = fmt.Fprintf(flag.CommandLine.Output(), "usage: fancyrpc-gen [flags] [package]\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	pattern := "."
+	if flag.NArg() > 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	if flag.NArg() == 1 {
+		pattern = flag.Arg(0)
+	}
+	var names []string
+	if *typeNames != "" {
+		names = strings.Split(*typeNames, ",")
+	}
+	if err := run(pattern, names, *output); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(pattern string, names []string, output string) error {
+	if output == "" {
+		output = defaultOutput
+	}
+	pkg, dir, err := load(pattern, filepath.Base(output))
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(output) {
+		output = filepath.Join(dir, output)
+	}
+	src, err := generate(pkg, names)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, src, 0o644)
+}
+
+// load 用 go list 找到 pattern 对应的唯一的包，跳过上一次生成的文件 skip 做类型检查。
+// 其他文件引用了生成的代码时会有类型错误，这些错误不影响服务类型的方法，因此忽略
+func load(pattern, skip string) (*types.Package, string, error) {
+	out, err := goList("-f", "{{.ImportPath}}\t{{.Dir}}\t{{join .GoFiles \"\\t\"}}", pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	if len(out) != 1 {
+		return nil, "", fmt.Errorf("%s matches %d packages, expect 1", pattern, len(out))
+	}
+	fields := strings.Split(out[0], "\t")
+	path, dir := fields[0], fields[1]
+
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range fields[2:] {
+		if name == skip {
+			continue
+		}
+		f, err := parser.ParseFile(fset, filepath.Join(dir, name), nil, 0)
+		if err != nil {
+			return nil, "", err
+		}
+		files = append(files, f)
+	}
+
+	// 依赖的包从 go build 缓存中的导出数据加载，比从源码做类型检查快得多。
+	// -e 使包本身编译失败时仍然列出依赖
+	out, err = goList("-e", "-export", "-deps", "-f", "{{.ImportPath}}\t{{.Export}}", pattern)
+	if err != nil {
+		return nil, "", err
+	}
+	exports := make(map[string]string, len(out))
+	for _, line := range out {
+		if p, file, ok := strings.Cut(line, "\t"); ok && file != "" {
+			exports[p] = file
+		}
+	}
+	lookup := func(p string) (io.ReadCloser, error) {
+		file, ok := exports[p]
+		if !ok {
+			return nil, fmt.Errorf("no export data for %s", p)
+		}
+		return os.Open(file)
+	}
+	conf := types.Config{
+		Importer: importer.ForCompiler(fset, "gc", lookup),
+		Error:    func(error) {},
+	}
+	pkg, err := conf.Check(path, fset, files, nil)
+	if pkg == nil {
+		return nil, "", err
+	}
+	return pkg, dir, nil
+}
+
+// goList 运行 go list，返回输出的每一行
+func goList(args ...string) ([]string, error) {
+	out, err := exec.Command("go", append([]string{"list"}, args...)...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return nil, fmt.Errorf("go list: %s", bytes.TrimSpace(exitErr.Stderr))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return strings.Split(strings.TrimSpace(string(out)), "\n"), nil
+}
+
+// service 生成代码所需的一个服务
+type service struct {
+	Name    string
+	Methods []method
+}
+
+// method Arg、Reply 是生成代码中的类型表达式，流式方法的是流中元素的类型
+type method struct {
+	Name         string
+	Arg, Reply   string
+	ReplyPtr     bool // protobuf 消息不能复制，回复以指针返回
+	ClientStream bool
+	ServerStream bool
+}
+
+func generate(pkg *types.Package, names []string) ([]byte, error) {
+	imports := newImports(pkg)
+	var services []service
+	scope := pkg.Scope()
+	explicit := len(names) > 0
+	if !explicit {
+		names = scope.Names() // 已经按名字排序
+	}
+	for _, name := range names {
+		obj, _ := scope.Lookup(name).(*types.TypeName)
+		var named *types.Named
+		if obj != nil && !obj.IsAlias() && obj.Exported() {
+			named, _ = obj.Type().(*types.Named)
+		}
+		if named == nil || named.TypeParams().Len() > 0 {
+			if explicit {
+				return nil, fmt.Errorf("%s is not an exported non-generic type in package %s", name, pkg.Path())
+			}
+			continue
+		}
+		svc := service{Name: name, Methods: serviceMethods(named, imports)}
+		if len(svc.Methods) > 0 {
+			services = append(services, svc)
+		} else if explicit {
+			return nil, fmt.Errorf("type %s has no RPC methods", name)
+		}
+	}
+	if len(services) == 0 {
+		return nil, fmt.Errorf("no RPC methods found in package %s", pkg.Path())
+	}
+
+	fancy := imports.fancy()
+	std, others := imports.list()
+	var buf bytes.Buffer
+	err := fileTemplate.Execute(&buf, struct {
+		Package    string
+		Fancy      string
+		StdImports []string
+		Imports    []string
+		Services   []service
+	}{pkg.Name(), fancy, std, others, services})
+	if err != nil {
+		return nil, err
+	}
+	src, err := format.Source(buf.Bytes())
+	if err != nil {
+		return nil, fmt.Errorf("format generated code: %w\n%s", err, buf.Bytes())
+	}
+	return src, nil
+}
+
+// serviceMethods 与 service.registMethod 的规则相同：导出的方法，
+// 参数为 (A, *R) 或 (context.Context, A, *R)，返回 error，A 和 R 是导出的或内置的类型
+func serviceMethods(named *types.Named, imports *importSet) []method {
+	var methods []method
+	mset := types.NewMethodSet(types.NewPointer(named))
+	for i := 0; i < mset.Len(); i++ {
+		fn := mset.At(i).Obj().(*types.Func)
+		if !fn.Exported() {
+			continue
+		}
+		sig := fn.Type().(*types.Signature)
+		params := sig.Params()
+		withContext := params.Len() == 3 && isContext(params.At(0).Type())
+		if params.Len() != 2 && !withContext {
+			continue
+		}
+		if sig.Results().Len() != 1 || !isError(sig.Results().At(0).Type()) {
+			continue
+		}
+		argType, replyType := params.At(params.Len()-2).Type(), params.At(params.Len()-1).Type()
+		if !isExportedOrBuiltin(argType) || !isExportedOrBuiltin(replyType) {
+			continue
+		}
+		m := method{Name: fn.Name()}
+		if elem, ok := fancyGeneric(replyType, "ServerStream"); ok {
+			m.ServerStream, replyType = true, elem
+		} else if ptr, ok := replyType.(*types.Pointer); ok {
+			replyType = ptr.Elem()
+			m.ReplyPtr = isProtoMessage(ptr)
+		} else {
+			continue
+		}
+		if elem, ok := fancyGeneric(argType, "RecvStream"); ok {
+			m.ClientStream, argType = true, elem
+		}
+		if !isExportedOrBuiltin(argType) || !isExportedOrBuiltin(replyType) {
+			continue
+		}
+		m.Arg, m.Reply = imports.typeString(argType), imports.typeString(replyType)
+		methods = append(methods, m)
+	}
+	sort.Slice(methods, func(i, j int) bool { return methods[i].Name < methods[j].Name })
+	return methods
+}
+
+// isExportedOrBuiltin 与 reflect 的判断一致：具名类型必须导出，未命名的类型都可以
+func isExportedOrBuiltin(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return !ok || named.Obj().Pkg() == nil || named.Obj().Exported()
+}
+
+func isContext(t types.Type) bool {
+	named, ok := t.(*types.Named)
+	return ok && named.Obj().Pkg() != nil && named.Obj().Pkg().Path() == "context" && named.Obj().Name() == "Context"
+}
+
+// isProtoMessage t 有 protobuf 生成代码的 ProtoReflect 方法
+func isProtoMessage(t types.Type) bool {
+	obj, _, _ := types.LookupFieldOrMethod(t, false, nil, "ProtoReflect")
+	_, ok := obj.(*types.Func)
+	return ok
+}
+
+func isError(t types.Type) bool {
+	return types.Identical(t, types.Universe.Lookup("error").Type())
+}
+
+// fancyGeneric t 是 *FancyRPC.name[E] 时返回 E
+func fancyGeneric(t types.Type, name string) (types.Type, bool) {
+	ptr, ok := t.(*types.Pointer)
+	if !ok {
+		return nil, false
+	}
+	named, ok := ptr.Elem().(*types.Named)
+	if !ok || named.Obj().Pkg() == nil || named.Obj().Pkg().Path() != fancyPath ||
+		named.Obj().Name() != name || named.TypeArgs().Len() != 1 {
+		return nil, false
+	}
+	return named.TypeArgs().At(0), true
+}
+
+// importSet 记录生成代码用到的包，同名的包加上序号作为别名
+type importSet struct {
+	self   *types.Package
+	byPath map[string]string
+	used   map[string]bool
+}
+
+func newImports(self *types.Package) *importSet {
+	s := &importSet{self: self, byPath: make(map[string]string), used: map[string]bool{"context": true}}
+	for _, name := range self.Scope().Names() {
+		s.used[name] = true
+	}
+	return s
+}
+
+func (s *importSet) qualifier(pkg *types.Package) string {
+	return s.name(pkg.Path(), pkg.Name())
+}
+
+func (s *importSet) name(path, name string) string {
+	if path == s.self.Path() {
+		return ""
+	}
+	if n, ok := s.byPath[path]; ok {
+		return n
+	}
+	n := name
+	for i := 2; s.used[n]; i++ {
+		n = fmt.Sprintf("%s%d", name, i)
+	}
+	s.used[n] = true
+	s.byPath[path] = n
+	return n
+}
+
+func (s *importSet) typeString(t types.Type) string {
+	return types.TypeString(t, s.qualifier)
+}
+
+// fancy 返回引用 FancyRPC 包时的前缀，例如 "FancyRPC."
+func (s *importSet) fancy() string {
+	if n := s.name(fancyPath, "FancyRPC"); n != "" {
+		return n + "."
+	}
+	return ""
+}
+
+// list 按路径排序的 import 声明，标准库在前；包名与路径最后一段不同时带上别名
+func (s *importSet) list() (std, others []string) {
+	paths := make([]string, 0, len(s.byPath))
+	for path := range s.byPath {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		spec := fmt.Sprintf("%q", path)
+		if name := s.byPath[path]; name != filepath.Base(path) {
+			spec = name + " " + spec
+		}
+		if isStd(path) {
+			std = append(std, spec)
+		} else {
+			others = append(others, spec)
+		}
+	}
+	return std, others
+}
+
+// isStd 标准库的路径第一段没有 "."，FancyRPC 自身除外
+func isStd(path string) bool {
+	first, _, _ := strings.Cut(path, "/")
+	return !strings.Contains(first, ".") && first != fancyPath
+}
+
+var fileTemplate = template.Must(template.New("file").Parse(`// Code generated by fancyrpc-gen. DO NOT EDIT.
+
+package {{.Package}}
+
+import (
+	"context"
+{{- range .StdImports}}
+	{{.}}
+{{- end}}
+{{range .Imports}}
+	{{.}}
+{{- end}}
+)
+{{range $svc := .Services}}
+// {{.Name}}Client 是 {{.Name}} 服务的类型化客户端
+type {{.Name}}Client struct {
+	cc {{$.Fancy}}ClientConn
+}
+
+// New{{.Name}}Client cc 可以是 *{{$.Fancy}}Client 或者 *{{$.Fancy}}Pool
+func New{{.Name}}Client(cc {{$.Fancy}}ClientConn) *{{.Name}}Client {
+	return &{{.Name}}Client{cc: cc}
+}
+{{range .Methods}}
+{{- if .ClientStream}}
+// {{.Name}} 打开 {{$svc.Name}}.{{.Name}} 的流，Send 的消息类型为 {{.Arg}}，Recv 的消息类型为 {{.Reply}}
+func (c *{{$svc.Name}}Client) {{.Name}}(ctx context.Context) (*{{$.Fancy}}ClientStream, error) {
+	return c.cc.OpenStream(ctx, "{{$svc.Name}}.{{.Name}}", new({{.Reply}}))
+}
+{{else if .ServerStream}}
+// {{.Name}} 调用 {{$svc.Name}}.{{.Name}}，Recv 的消息类型为 {{.Reply}}
+func (c *{{$svc.Name}}Client) {{.Name}}(ctx context.Context, args {{.Arg}}) (*{{$.Fancy}}ClientStream, error) {
+	return c.cc.Stream(ctx, "{{$svc.Name}}.{{.Name}}", args, new({{.Reply}}))
+}
+{{else if .ReplyPtr}}
+// {{.Name}} 调用 {{$svc.Name}}.{{.Name}}
+func (c *{{$svc.Name}}Client) {{.Name}}(ctx context.Context, args {{.Arg}}) (*{{.Reply}}, error) {
+	reply := new({{.Reply}})
+	err := c.cc.CallContext(ctx, "{{$svc.Name}}.{{.Name}}", args, reply)
+	return reply, err
+}
+{{else}}
+// {{.Name}} 调用 {{$svc.Name}}.{{.Name}}
+func (c *{{$svc.Name}}Client) {{.Name}}(ctx context.Context, args {{.Arg}}) ({{.Reply}}, error) {
+	var reply {{.Reply}}
+	err := c.cc.CallContext(ctx, "{{$svc.Name}}.{{.Name}}", args, &reply)
+	return reply, err
+}
+{{end}}
+{{- end}}
+// Register{{.Name}} 在 server 上注册 rcvr，服务名为 "{{.Name}}"
+func Register{{.Name}}(server *{{$.Fancy}}Server, rcvr *{{.Name}}) error {
+	return server.Register(rcvr)
+}
+{{end}}`))
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testdata/svc/fancyrpc_gen.go 是生成的结果，修改生成器后用
+// go run ./cmd/fancyrpc-gen ./cmd/fancyrpc-gen/testdata/svc 重新生成
+func TestGenerate(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "out.go")
+	if err := run("./testdata/svc", nil, out); err != nil {
+		t.Fatalf("generate failed: %v", err)
+	}
+	got, _ := os.ReadFile(out)
+	want, err := os.ReadFile("testdata/svc/fancyrpc_gen.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != string(want) {
+		t.Fatalf("generated code differs from testdata/svc/fancyrpc_gen.go:\n%s", got)
+	}
+}
+
+func TestGenerate_Errors(t *testing.T) {
+	cases := []struct {
+		types []string
+		err   string
+	}{
+		{[]string{"Empty"}, "type Empty has no RPC methods"},
+		{[]string{"Nope"}, "Nope is not an exported non-generic type"},
+		{[]string{"hidden"}, "hidden is not an exported non-generic type"},
+	}
+	for _, c := range cases {
+		err := run("./testdata/svc", c.types, filepath.Join(t.TempDir(), "out.go"))
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%v: expect error %q, got %v", c.types, c.err, err)
+		}
+	}
+}
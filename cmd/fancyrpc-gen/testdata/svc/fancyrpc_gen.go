@@ -0,0 +1,69 @@
+// Code generated by fancyrpc-gen. DO NOT EDIT.
+
+package svc
+
+import (
+	"context"
+	time2 "time"
+
+	"FancyRPC"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// CalcClient 是 Calc 服务的类型化客户端
+type CalcClient struct {
+	cc FancyRPC.ClientConn
+}
+
+// NewCalcClient cc 可以是 *FancyRPC.Client 或者 *FancyRPC.Pool
+func NewCalcClient(cc FancyRPC.ClientConn) *CalcClient {
+	return &CalcClient{cc: cc}
+}
+
+// Add 调用 Calc.Add
+func (c *CalcClient) Add(ctx context.Context, args Pair) (int, error) {
+	var reply int
+	err := c.cc.CallContext(ctx, "Calc.Add", args, &reply)
+	return reply, err
+}
+
+// Div 调用 Calc.Div
+func (c *CalcClient) Div(ctx context.Context, args Pair) (float64, error) {
+	var reply float64
+	err := c.cc.CallContext(ctx, "Calc.Div", args, &reply)
+	return reply, err
+}
+
+// Echo 打开 Calc.Echo 的流，Send 的消息类型为 Row，Recv 的消息类型为 Row
+func (c *CalcClient) Echo(ctx context.Context) (*FancyRPC.ClientStream, error) {
+	return c.cc.OpenStream(ctx, "Calc.Echo", new(Row))
+}
+
+// List 调用 Calc.List，Recv 的消息类型为 Row
+func (c *CalcClient) List(ctx context.Context, args int) (*FancyRPC.ClientStream, error) {
+	return c.cc.Stream(ctx, "Calc.List", args, new(Row))
+}
+
+// Upload 打开 Calc.Upload 的流，Send 的消息类型为 int，Recv 的消息类型为 int
+func (c *CalcClient) Upload(ctx context.Context) (*FancyRPC.ClientStream, error) {
+	return c.cc.OpenStream(ctx, "Calc.Upload", new(int))
+}
+
+// Upper 调用 Calc.Upper
+func (c *CalcClient) Upper(ctx context.Context, args *wrapperspb.StringValue) (*wrapperspb.StringValue, error) {
+	reply := new(wrapperspb.StringValue)
+	err := c.cc.CallContext(ctx, "Calc.Upper", args, reply)
+	return reply, err
+}
+
+// Wait 调用 Calc.Wait
+func (c *CalcClient) Wait(ctx context.Context, args time2.Duration) (time2.Time, error) {
+	var reply time2.Time
+	err := c.cc.CallContext(ctx, "Calc.Wait", args, &reply)
+	return reply, err
+}
+
+// RegisterCalc 在 server 上注册 rcvr，服务名为 "Calc"
+func RegisterCalc(server *FancyRPC.Server, rcvr *Calc) error {
+	return server.Register(rcvr)
+}
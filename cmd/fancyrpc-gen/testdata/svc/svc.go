@@ -0,0 +1,48 @@
+package svc
+
+import (
+	"context"
+	stdtime "time"
+
+	"FancyRPC"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Pair struct{ A, B int }
+
+type Row struct {
+	I    int
+	Name string
+}
+
+// time 与标准库同名，生成的代码需要给 "time" 加别名
+type time struct{}
+
+type Calc struct{}
+
+func (Calc) Add(ctx context.Context, p Pair, sum *int) error { return nil }
+func (*Calc) Div(p Pair, q *float64) error                   { return nil }
+func (Calc) Wait(d stdtime.Duration, at *stdtime.Time) error { return nil }
+func (Calc) Upper(s *wrapperspb.StringValue, r *wrapperspb.StringValue) error {
+	return nil
+}
+func (Calc) List(n int, stream *FancyRPC.ServerStream[Row]) error { return nil }
+func (Calc) Upload(in *FancyRPC.RecvStream[int], sum *int) error  { return nil }
+func (Calc) Echo(in *FancyRPC.RecvStream[Row], out *FancyRPC.ServerStream[Row]) error {
+	return nil
+}
+
+// 以下方法不满足规则
+func (Calc) lower(p Pair, sum *int) error      { return nil }
+func (Calc) Two(p Pair, sum *int) (int, error) { return 0, nil }
+func (Calc) Hidden(p hidden, sum *int) error   { return nil }
+func (Calc) NoPtr(p Pair, sum int) error       { return nil }
+func (Calc) Local(t time, sum *int) error      { return nil }
+
+type hidden struct{}
+
+// Empty 没有 RPC 方法，不生成代码
+type Empty struct{}
+
+func (Empty) String() string { return "" }
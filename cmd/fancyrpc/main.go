@@ -0,0 +1,255 @@
+// fancyrpc 命令行客户端，通过服务端的反射服务（WithReflection）查询服务并发起调用，
+// 参数以 JSON 给出，按服务端描述的类型转换后发送，回复以 JSON 输出。
+//
+//	fancyrpc -addr 127.0.0.1:9999 list [Service]
+//	fancyrpc -addr unix:///tmp/fancy.sock describe Foo.Sum
+//	fancyrpc -addr http://127.0.0.1:8080 -H tenant=a call Foo.Sum '{"Num1":1,"Num2":2}'
+//
+// 参数为 "-" 时从标准输入读取，省略时为零值。
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"FancyRPC"
+	"FancyRPC/codec"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// metadataFlag 可重复的 -H key=value
+type metadataFlag FancyRPC.Metadata
+
+func (m metadataFlag) String() string { return fmt.Sprint(FancyRPC.Metadata(m)) }
+
+func (m metadataFlag) Set(s string) error {
+	k, v, ok := strings.Cut(s, "=")
+	if !ok || k == "" {
+		return errors.New("metadata must be key=value")
+	}
+	m[strings.ToLower(k)] = v
+	return nil
+}
+
+// codecNames -codec 的简写，也可以直接给出 codec 的 Type
+var codecNames = map[string]string{
+	"gob":      codec.GobType,
+	"msgpack":  codec.MsgpackType,
+	"protobuf": codec.ProtobufType,
+}
+
+func main() {
+	md := metadataFlag{}
+	addr := flag.String("addr", "127.0.0.1:9999", "server address: host:port, tcp://host:port, unix:///path, http://host:port[/path] (CONNECT) or ws://host:port/path")
+	codecName := flag.String("codec", "gob", "codec: gob, msgpack or protobuf (list and describe only)")
+	timeout := flag.Duration("timeout", 5*time.Second, "timeout for dialing and each call, 0 means no timeout")
+	flag.Var(md, "H", "metadata sent with calls as key=value, may be repeated")
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		_, _ = fmt.Fprintf(out, "usage:\n  fancyrpc [flags] list [Service]\n  fancyrpc [flags] describe Service.Method\n  fancyrpc [flags] call Service.Method [json|-]\n\nflags:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	cmd, args := flag.Arg(0), flag.Args()
+	if len(args) > 0 {
+		args = args[1:]
+	}
+	valid := cmd == "list" && len(args) <= 1 ||
+		cmd == "describe" && len(args) == 1 ||
+		cmd == "call" && (len(args) == 1 || len(args) == 2)
+	if !valid {
+		flag.Usage()
+		os.Exit(2)
+	}
+	codecType := *codecName
+	if t, ok := codecNames[codecType]; ok {
+		codecType = t
+	}
+
+	ctx := FancyRPC.ContextWithMetadata(context.Background(), FancyRPC.Metadata(md))
+	if *timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, *timeout)
+		defer cancel()
+	}
+	client, err := dial(ctx, *addr, &FancyRPC.Option{CodecType: codecType})
+	if err != nil {
+		fatal(err)
+	}
+	defer func() { _ = client.Close() }()
+
+	var out interface{}
+	switch cmd {
+	case "list":
+		out, err = listServices(ctx, client, codecType, strings.Join(args, ""))
+	case "describe":
+		out, err = describeMethod(ctx, client, codecType, args[0])
+	case "call":
+		arg := ""
+		if len(args) == 2 {
+			arg = args[1]
+		}
+		out, err = call(ctx, client, codecType, args[0], arg)
+	}
+	if err != nil {
+		fatal(err)
+	}
+	b, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		fatal(err)
+	}
+	fmt.Println(string(b))
+}
+
+func fatal(err error) {
+	var rpcErr *FancyRPC.Error
+	if errors.As(err, &rpcErr) {
+		_, _ = fmt.Fprintf(os.Stderr, "fancyrpc: %s: %s\n", rpcErr.Code, rpcErr.Message)
+		for k, v := range rpcErr.Details {
+			_, _ = fmt.Fprintf(os.Stderr, "  %s: %s\n", k, v)
+		}
+	} else {
+		_, _ = fmt.Fprintf(os.Stderr, "fancyrpc: %v\n", err)
+	}
+	os.Exit(1)
+}
+
+// dial 按地址的 scheme 选择传输方式，没有 scheme 时为 TCP
+func dial(ctx context.Context, addr string, opt *FancyRPC.Option) (*FancyRPC.Client, error) {
+	if !strings.Contains(addr, "://") {
+		addr = "tcp://" + addr
+	}
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, err
+	}
+	type result struct {
+		client *FancyRPC.Client
+		err    error
+	}
+	ch := make(chan result, 1)
+	go func() {
+		var r result
+		switch u.Scheme {
+		case "tcp":
+			r.client, r.err = FancyRPC.Dial("tcp", u.Host, opt)
+		case "unix":
+			r.client, r.err = FancyRPC.DialUnix(u.Path, opt)
+		case "http":
+			path := u.Path
+			if path == "" {
+				path = FancyRPC.DefaultRPCPath
+			}
+			r.client, r.err = FancyRPC.DialHTTPPath("tcp", u.Host, path, opt)
+		case "ws", "wss":
+			r.client, r.err = FancyRPC.DialWebSocket(addr, opt)
+		default:
+			r.err = fmt.Errorf("unsupported address scheme %q", u.Scheme)
+		}
+		ch <- r
+	}()
+	select {
+	case r := <-ch:
+		return r.client, r.err
+	case <-ctx.Done():
+		// 连接建立后没有人关闭，交给后台回收
+		go func() {
+			if r := <-ch; r.client != nil {
+				_ = r.client.Close()
+			}
+		}()
+		return nil, fmt.Errorf("dial %s: %w", addr, ctx.Err())
+	}
+}
+
+// listServices protobuf 编码只能传输 proto.Message，通过 Reflection.Schema 取得 JSON
+func listServices(ctx context.Context, client *FancyRPC.Client, codecType, name string) ([]FancyRPC.ServiceDesc, error) {
+	var services []FancyRPC.ServiceDesc
+	if codecType == codec.ProtobufType {
+		reply := new(wrapperspb.BytesValue)
+		if err := client.CallContext(ctx, FancyRPC.ReflectionService+".Schema", wrapperspb.String(name), reply); err != nil {
+			return nil, err
+		}
+		return services, json.Unmarshal(reply.GetValue(), &services)
+	}
+	err := client.CallContext(ctx, FancyRPC.ReflectionService+".ListServices", name, &services)
+	return services, err
+}
+
+func describeMethod(ctx context.Context, client *FancyRPC.Client, codecType, serviceMethod string) (*FancyRPC.MethodDesc, error) {
+	if codecType != codec.ProtobufType {
+		m := new(FancyRPC.MethodDesc)
+		return m, client.CallContext(ctx, FancyRPC.ReflectionService+".DescribeMethod", serviceMethod, m)
+	}
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, fmt.Errorf("method must be Service.Method: %s", serviceMethod)
+	}
+	services, err := listServices(ctx, client, codecType, serviceMethod[:dot])
+	if err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		for i := range s.Methods {
+			if s.Methods[i].Name == serviceMethod[dot+1:] {
+				return &s.Methods[i], nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("can't find method %s", serviceMethod)
+}
+
+// call 按方法的描述构造参数和回复的类型，参数从 JSON 解码
+func call(ctx context.Context, client *FancyRPC.Client, codecType, serviceMethod, arg string) (interface{}, error) {
+	if codecType == codec.ProtobufType {
+		return nil, errors.New("call does not support the protobuf codec: arguments must be generated proto messages")
+	}
+	m, err := describeMethod(ctx, client, codecType, serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	if m.ClientStream || m.ServerStream {
+		return nil, fmt.Errorf("%s is a streaming method", serviceMethod)
+	}
+	argType, err := m.ArgType.Type()
+	if err != nil {
+		return nil, err
+	}
+	replyType, err := m.ReplyType.Type()
+	if err != nil {
+		return nil, err
+	}
+
+	if arg == "-" {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return nil, err
+		}
+		arg = string(b)
+	}
+	argv := reflect.New(argType)
+	if strings.TrimSpace(arg) != "" {
+		if err := json.Unmarshal([]byte(arg), argv.Interface()); err != nil {
+			return nil, fmt.Errorf("decode arguments as %s: %w", m.ArgType.Name, err)
+		}
+	}
+	if argType.Kind() == reflect.Ptr && argv.Elem().IsNil() {
+		// 编码器不能发送 nil 指针
+		argv.Elem().Set(reflect.New(argType.Elem()))
+	}
+	replyv := reflect.New(replyType)
+	if err := client.CallContext(ctx, serviceMethod, argv.Elem().Interface(), replyv.Interface()); err != nil {
+		return nil, err
+	}
+	return replyv.Interface(), nil
+}
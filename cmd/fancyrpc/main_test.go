@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"FancyRPC"
+	"FancyRPC/codec"
+)
+
+type Pair struct {
+	A, B int
+}
+
+type Calc struct{}
+
+func (Calc) Add(p Pair, reply *int) error {
+	*reply = p.A + p.B
+	return nil
+}
+
+func (Calc) Count(n int, stream *FancyRPC.ServerStream[int]) error {
+	return nil
+}
+
+// startServer 通过 httptest 提供 CONNECT 服务，返回 http:// 形式的地址
+func startServer(t *testing.T) string {
+	t.Helper()
+	server := FancyRPC.NewServer(FancyRPC.WithReflection())
+	if err := server.Register(Calc{}); err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewServer(server.ConnectHandler())
+	t.Cleanup(srv.Close)
+	return srv.URL + FancyRPC.DefaultRPCPath
+}
+
+func dialTest(t *testing.T, addr, codecType string) *FancyRPC.Client {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	client, err := dial(ctx, addr, &FancyRPC.Option{CodecType: codecType})
+	if err != nil {
+		t.Fatalf("dial failed: %v", err)
+	}
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestList(t *testing.T) {
+	addr := startServer(t)
+	for _, ct := range []string{codec.GobType, codec.ProtobufType} {
+		client := dialTest(t, addr, ct)
+		services, err := listServices(context.Background(), client, ct, "")
+		if err != nil {
+			t.Fatalf("%s: list failed: %v", ct, err)
+		}
+		var names []string
+		for _, s := range services {
+			names = append(names, s.Name)
+		}
+		if strings.Join(names, ",") != "Calc,Reflection" || len(services[0].Methods) != 2 {
+			t.Fatalf("%s: unexpected services %+v", ct, services)
+		}
+		m, err := describeMethod(context.Background(), client, ct, "Calc.Count")
+		if err != nil || !m.ServerStream || m.ArgType.Name != "int" {
+			t.Fatalf("%s: unexpected method %+v %v", ct, m, err)
+		}
+	}
+}
+
+func TestCall(t *testing.T) {
+	addr := startServer(t)
+	client := dialTest(t, addr, codec.MsgpackType)
+	client2 := dialTest(t, addr, codec.GobType)
+	ctx := context.Background()
+
+	for _, c := range []struct {
+		client *FancyRPC.Client
+		ct     string
+	}{{client, codec.MsgpackType}, {client2, codec.GobType}} {
+		out, err := call(ctx, c.client, c.ct, "Calc.Add", `{"A":1,"B":2}`)
+		if err != nil {
+			t.Fatalf("%s: call failed: %v", c.ct, err)
+		}
+		if b, _ := json.Marshal(out); string(b) != "3" {
+			t.Fatalf("%s: unexpected reply %s", c.ct, b)
+		}
+	}
+
+	cases := []struct {
+		ct, method, arg, err string
+	}{
+		{codec.GobType, "Calc.Count", "1", "Calc.Count is a streaming method"},
+		{codec.GobType, "Calc.Add", `{"A":"x"}`, "decode arguments as main.Pair"},
+		{codec.GobType, "Calc.Nope", "", "can't find method Nope"},
+		{codec.ProtobufType, "Calc.Add", "", "does not support the protobuf codec"},
+	}
+	for _, c := range cases {
+		_, err := call(ctx, client2, c.ct, c.method, c.arg)
+		if err == nil || !strings.Contains(err.Error(), c.err) {
+			t.Fatalf("%s: expect error %q, got %v", c.method, c.err, err)
+		}
+	}
+}
@@ -1,6 +1,9 @@
 package codec
 
-import "io"
+import (
+	"errors"
+	"io"
+)
 
 // Header 客户端调用方式
 //
@@ -8,10 +11,57 @@ import "io"
 // 所以定义下面的结构体
 
 type Header struct {
-	ServerMethod  string // ServiceMethod 是服务名和方法名，通常与 Go 语言中的结构体和方法相映射。
-	Seq           uint64 // Seq 是请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求。
-	Error         string // Error 是错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中。
-	ServiceMethod string
+	ServiceMethod string            // ServiceMethod 是服务名和方法名，通常与 Go 语言中的结构体和方法相映射。
+	Seq           uint64            // Seq 是请求的序号，也可以认为是某个请求的 ID，用来区分不同的请求。
+	Error         string            // Error 是错误信息，客户端置为空，服务端如果如果发生错误，将错误信息置于 Error 中。
+	TraceParent   string            // TraceParent 是 W3C traceparent，客户端开启追踪时填写，服务端据此延续 trace。
+	Compression   string            // Compression 是 body 使用的压缩算法，为空表示 body 未压缩。
+	Type          FrameType         // Type 是帧类型，普通请求和响应为 FrameCall，流式调用使用其余类型。
+	Window        uint32            // Window 是 FrameWindow 归还给对端的发送额度（消息数）。
+	Reverse       bool              // Reverse 表示该帧属于服务端发起的反向调用，两个方向的 Seq 各自编号、互不冲突。
+	Metadata      map[string]string // Metadata 是请求附带的键值对，只在请求帧上发送。
+	Code          uint32            // Code 是错误码，取值见 FancyRPC.Code，Error 为空时为 0。
+	ErrorDetails  map[string]string // ErrorDetails 是错误的附加信息。
+}
+
+// FrameType 帧类型。同一个 Seq 上可以有多个帧，例如服务端流式方法会先发送若干 FrameData，最后发送 FrameEnd。
+type FrameType uint8
+
+const (
+	FrameCall      FrameType = iota // 普通的请求或响应，流式调用的第一帧也是 FrameCall
+	FrameData                       // 流中的一条消息，两个方向都可以发送
+	FrameEnd                        // 服务端发送的流结束帧，Error 不为空时表示流以错误结束
+	FrameCloseSend                  // 客户端不再发送消息，服务端的 Recv 随后返回 io.EOF
+	FrameCancel                     // 客户端取消流，服务端取消处理函数的 context
+	FrameWindow                     // 流控：归还 Window 条消息的发送额度
+	FrameNotify                     // 单向调用，服务端执行方法但不回复
+	FramePing                       // 心跳，对端收到后回复 FramePong
+	FramePong                       // 心跳的回复
+)
+
+func (t FrameType) String() string {
+	switch t {
+	case FrameCall:
+		return "call"
+	case FrameData:
+		return "data"
+	case FrameEnd:
+		return "end"
+	case FrameCloseSend:
+		return "close_send"
+	case FrameCancel:
+		return "cancel"
+	case FrameWindow:
+		return "window"
+	case FrameNotify:
+		return "notify"
+	case FramePing:
+		return "ping"
+	case FramePong:
+		return "pong"
+	default:
+		return "unknown"
+	}
 }
 
 // Codec codec 模块用于对pb的编码解码，对传输的内容做了规范，抽象出接口
@@ -24,20 +74,41 @@ type Codec interface {
 	Write(*Header, interface{}) error
 }
 
+// BodyMarshaler 把单个 body 独立编码成字节，CompressCodec 借助它在压缩前后转换 body
+type BodyMarshaler interface {
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(data []byte, v interface{}) error
+}
+
+// ErrMarshalBody body 编码失败。Write 返回包装了它的错误时没有写出任何字节，连接仍然可用
+var ErrMarshalBody = errors.New("rpc codec: marshal body")
+
 // NewCodecFunc NewCodecFunc是一个函数类型，它定义了一个用于创建Codec对象的函数。
 // 该函数接收一个io.ReadWriteCloser类型的参数，用于创建一个新的Codec对象，并返回该对象的指针。
 type NewCodecFunc func(closer io.ReadWriteCloser) Codec
 
 // 这里是两种codec
 const (
-	GobType  string = "application/gob"
-	JsonType string = "application/json"
+	GobType      string = "application/gob"
+	JsonType     string = "application/json"
+	ProtobufType string = "application/protobuf"
+	MsgpackType  string = "application/msgpack"
 )
 
 // NewCodecFuncMap 映射关系
 var NewCodecFuncMap map[string]NewCodecFunc
 
+// BodyMarshalerMap 每种 codec 对应的 BodyMarshaler
+var BodyMarshalerMap map[string]BodyMarshaler
+
 func init() {
 	NewCodecFuncMap = make(map[string]NewCodecFunc)
 	NewCodecFuncMap[GobType] = NewGobCodec // 客户端和服务端可以通过 Codec 的 Type 得到构造函数
+	NewCodecFuncMap[ProtobufType] = NewProtobufCodec
+	NewCodecFuncMap[MsgpackType] = NewMsgpackCodec
+
+	BodyMarshalerMap = make(map[string]BodyMarshaler)
+	BodyMarshalerMap[GobType] = gobMarshaler{}
+	BodyMarshalerMap[ProtobufType] = protobufMarshaler{}
+	BodyMarshalerMap[MsgpackType] = msgpackMarshaler{}
 }
@@ -0,0 +1,208 @@
+package codec
+
+//body 压缩，以包装器的形式作用于任意 Codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+// Compressor 压缩算法，通过 RegisterCompressor 注册后即可在 Option.Compression 中按名字协商。
+// 内置了 gzip 和 snappy：gzip 压缩率高，snappy 速度快、CPU 开销小，zstd 等可以用第三方实现注册进来。
+type Compressor interface {
+	Name() string
+	Compress(src []byte) ([]byte, error)
+	Decompress(src []byte) ([]byte, error)
+}
+
+const (
+	GzipCompression   = "gzip"
+	SnappyCompression = "snappy"
+)
+
+var (
+	compressorsMu sync.RWMutex
+	compressors   = make(map[string]Compressor)
+)
+
+// RegisterCompressor 注册压缩算法，同名的会被覆盖
+func RegisterCompressor(c Compressor) {
+	compressorsMu.Lock()
+	defer compressorsMu.Unlock()
+	compressors[c.Name()] = c
+}
+
+// GetCompressor 按名字查找压缩算法，未注册时返回 nil
+func GetCompressor(name string) Compressor {
+	compressorsMu.RLock()
+	defer compressorsMu.RUnlock()
+	return compressors[name]
+}
+
+func init() {
+	RegisterCompressor(gzipCompressor{})
+	RegisterCompressor(snappyCompressor{})
+}
+
+type gzipCompressor struct{}
+
+var gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(nil) }}
+
+func (gzipCompressor) Name() string { return GzipCompression }
+
+func (gzipCompressor) Compress(src []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(w)
+	w.Reset(&buf)
+	if _, err := w.Write(src); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gzipCompressor) Decompress(src []byte) ([]byte, error) {
+	return gzipCompressor{}.decompressLimit(src, 0)
+}
+
+// decompressLimit 解压出的数据超过 limit 时立即停止，不会先把整个 body 解压到内存
+func (gzipCompressor) decompressLimit(src []byte, limit int) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = r.Close() }()
+	if limit <= 0 {
+		return io.ReadAll(r)
+	}
+	b, err := io.ReadAll(io.LimitReader(r, int64(limit)+1))
+	if err == nil && len(b) > limit {
+		return nil, tooLarge(uint64(len(b)), limit)
+	}
+	return b, err
+}
+
+// snappyCompressor 使用 snappy 的块格式，整个 body 压缩为一块
+type snappyCompressor struct{}
+
+func (snappyCompressor) Name() string { return SnappyCompression }
+
+func (snappyCompressor) Compress(src []byte) ([]byte, error) {
+	return snappy.Encode(nil, src), nil
+}
+
+func (snappyCompressor) Decompress(src []byte) ([]byte, error) {
+	return snappy.Decode(nil, src)
+}
+
+// decompressLimit 块的开头记录了解压后的长度，超过 limit 时不分配内存直接返回
+func (snappyCompressor) decompressLimit(src []byte, limit int) ([]byte, error) {
+	n, err := snappy.DecodedLen(src)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && n > limit {
+		return nil, tooLarge(uint64(n), limit)
+	}
+	return snappy.Decode(nil, src)
+}
+
+// limitedDecompressor 能在解压过程中检查大小的 Compressor，其余的解压完成后再检查
+type limitedDecompressor interface {
+	decompressLimit(src []byte, limit int) ([]byte, error)
+}
+
+func decompress(c Compressor, src []byte, limit int) ([]byte, error) {
+	if ld, ok := c.(limitedDecompressor); ok {
+		return ld.decompressLimit(src, limit)
+	}
+	b, err := c.Decompress(src)
+	if err == nil && limit > 0 && len(b) > limit {
+		return nil, tooLarge(uint64(len(b)), limit)
+	}
+	return b, err
+}
+
+// CompressCodec 包装任意 Codec：body 先用 BodyMarshaler 编码成字节，
+// 超过阈值时压缩并在 Header.Compression 中标明算法，再作为 []byte 交给内层 Codec 传输。
+// 收到的 Header.Compression 不为空时先解压再解码。
+type CompressCodec struct {
+	Codec
+	marshaler  BodyMarshaler
+	compressor Compressor
+	threshold  int
+	maxBody    int // 解压后的 body 大小上限
+	// 上一个 ReadHeader 读到的压缩算法，ReadHeader 和 ReadBody 总是成对串行调用
+	compression string
+}
+
+var _ Codec = (*CompressCodec)(nil)
+
+// NewCompressCodec 小于 threshold 字节的 body 不压缩
+func NewCompressCodec(inner Codec, m BodyMarshaler, c Compressor, threshold int) Codec {
+	return &CompressCodec{Codec: inner, marshaler: m, compressor: c, threshold: threshold}
+}
+
+// SetMaxSizes 内层 Codec 限制传输的字节数，解压后的 body 同样不能超过 maxBody
+func (c *CompressCodec) SetMaxSizes(maxHeader, maxBody int) {
+	c.maxBody = maxBody
+	if l, ok := c.Codec.(SizeLimiter); ok {
+		l.SetMaxSizes(maxHeader, maxBody)
+	}
+}
+
+func (c *CompressCodec) ReadHeader(h *Header) error {
+	if err := c.Codec.ReadHeader(h); err != nil {
+		return err
+	}
+	c.compression = h.Compression
+	return nil
+}
+
+func (c *CompressCodec) ReadBody(body interface{}) error {
+	var b []byte
+	if err := c.Codec.ReadBody(&b); err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	if c.compression != "" {
+		comp := GetCompressor(c.compression)
+		if comp == nil {
+			return fmt.Errorf("rpc codec: unknown compression %q", c.compression)
+		}
+		var err error
+		if b, err = decompress(comp, b, c.maxBody); err != nil {
+			return fmt.Errorf("rpc codec: %s decompress body: %w", c.compression, err)
+		}
+	}
+	return c.marshaler.Unmarshal(b, body)
+}
+
+func (c *CompressCodec) Write(h *Header, body interface{}) error {
+	b, err := c.marshaler.Marshal(body)
+	if err != nil {
+		if errors.Is(err, ErrMarshalBody) {
+			return err
+		}
+		return fmt.Errorf("%w: %w", ErrMarshalBody, err)
+	}
+	h.Compression = ""
+	if len(b) >= c.threshold {
+		if b, err = c.compressor.Compress(b); err != nil {
+			return fmt.Errorf("rpc codec: %s compress body: %w", c.compressor.Name(), err)
+		}
+		h.Compression = c.compressor.Name()
+	}
+	return c.Codec.Write(h, b)
+}
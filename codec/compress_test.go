@@ -0,0 +1,95 @@
+package codec
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+)
+
+// reverseCompressor 只是把字节倒序，用来验证自定义算法的注册和协商
+type reverseCompressor struct{}
+
+func (reverseCompressor) Name() string { return "reverse" }
+
+func (reverseCompressor) Compress(src []byte) ([]byte, error) {
+	dst := make([]byte, len(src))
+	for i, b := range src {
+		dst[len(src)-1-i] = b
+	}
+	return dst, nil
+}
+
+func (r reverseCompressor) Decompress(src []byte) ([]byte, error) { return r.Compress(src) }
+
+func TestCompressCodec_Threshold(t *testing.T) {
+	for _, typ := range []string{GobType, MsgpackType} {
+		for _, name := range []string{GzipCompression, SnappyCompression} {
+			a, b := net.Pipe()
+			c := GetCompressor(name)
+			client := NewCompressCodec(NewCodecFuncMap[typ](a), BodyMarshalerMap[typ], c, 64)
+			server := NewCompressCodec(NewCodecFuncMap[typ](b), BodyMarshalerMap[typ], c, 64)
+
+			large := strings.Repeat("fancy", 100)
+			go func() {
+				_ = client.Write(&Header{Seq: 1}, "small")
+				_ = client.Write(&Header{Seq: 2}, large)
+			}()
+			for _, want := range []struct {
+				body        string
+				compression string
+			}{{"small", ""}, {large, name}} {
+				var h Header
+				var got string
+				if err := server.ReadHeader(&h); err != nil || h.Compression != want.compression {
+					t.Fatalf("%s/%s: expect compression %q, got %+v %v", typ, name, want.compression, h, err)
+				}
+				if err := server.ReadBody(&got); err != nil || got != want.body {
+					t.Fatalf("%s/%s: body mismatch %v", typ, name, err)
+				}
+			}
+			_ = client.Close()
+			_ = server.Close()
+		}
+	}
+}
+
+func TestSnappyCompressor_Limit(t *testing.T) {
+	c := GetCompressor(SnappyCompression)
+	src, err := c.Compress([]byte(strings.Repeat("x", 4096)))
+	if err != nil || len(src) >= 4096 {
+		t.Fatalf("snappy did not compress: %d bytes %v", len(src), err)
+	}
+	if b, err := decompress(c, src, 4096); err != nil || len(b) != 4096 {
+		t.Fatalf("unexpected result %d bytes %v", len(b), err)
+	}
+	if _, err := decompress(c, src, 1024); !errors.Is(err, ErrMessageTooLarge) {
+		t.Fatalf("expect ErrMessageTooLarge, got %v", err)
+	}
+	if _, err := decompress(c, []byte{0xff, 0xff, 0xff}, 0); err == nil {
+		t.Fatal("expect error for corrupted input")
+	}
+}
+
+func TestCompressCodec_CustomCompressor(t *testing.T) {
+	RegisterCompressor(reverseCompressor{})
+	c := GetCompressor("reverse")
+	if c == nil {
+		t.Fatal("reverse compressor not registered")
+	}
+	a, b := net.Pipe()
+	client := NewCompressCodec(NewGobCodec(a), BodyMarshalerMap[GobType], c, 0)
+	server := NewCompressCodec(NewGobCodec(b), BodyMarshalerMap[GobType], c, 0)
+	defer func() { _ = client.Close(); _ = server.Close() }()
+
+	go func() { _ = client.Write(&Header{Seq: 1}, []byte("abc")) }()
+	var h Header
+	var got []byte
+	if err := server.ReadHeader(&h); err != nil || h.Compression != "reverse" {
+		t.Fatalf("unexpected header %+v %v", h, err)
+	}
+	if err := server.ReadBody(&got); err != nil || !bytes.Equal(got, []byte("abc")) {
+		t.Fatalf("body mismatch %q %v", got, err)
+	}
+}
@@ -4,9 +4,10 @@ package codec
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/gob"
+	"fmt"
 	"io"
-	"log"
 )
 
 // GobCodec gob 二进制协议
@@ -15,6 +16,9 @@ type GobCodec struct {
 	buf  *bufio.Writer      //缓存写入的数据
 	dec  *gob.Decoder       //用于解码数据
 	enc  *gob.Encoder       //用于编码数据
+	r    *gobReader         //按消息检查大小
+
+	maxHeader, maxBody int
 }
 
 var _ Codec = (*GobCodec)(nil) //通过将(*GobCodec)(nil)赋值给_变量，我们可以检查GobCodec类型是否实现了Codec接口
@@ -26,25 +30,30 @@ var _ Codec = (*GobCodec)(nil) //通过将(*GobCodec)(nil)赋值给_变量，我
 // NewGobCodec GobCodec 结构体(类) 实现了Codec接口规定的所有方法,故该NewGobCodec函数可以返回Codec
 func NewGobCodec(conn io.ReadWriteCloser) Codec {
 	buf := bufio.NewWriter(conn)
+	r := &gobReader{r: bufio.NewReader(conn)}
 	return &GobCodec{
 		conn: conn,
 		buf:  buf,
-		dec:  gob.NewDecoder(conn),
+		dec:  gob.NewDecoder(r),
 		enc:  gob.NewEncoder(buf),
+		r:    r,
 	}
 }
 
+// SetMaxSizes 限制的是单条 gob 消息的长度，body 第一次出现某个类型时附带的类型描述单独计算
+func (c *GobCodec) SetMaxSizes(maxHeader, maxBody int) {
+	c.maxHeader, c.maxBody = maxHeader, maxBody
+}
+
 //实现 ReadHeader、ReadBody、Write 和 Close 方法。实现了这些方法后GobCodec会成为Codec接口类型的子类
 
 func (c *GobCodec) ReadHeader(h *Header) error {
-
-	log.Println("GOB ReadHeader")
-	log.Printf("GOB ReadHeader is %v", h)
+	c.r.limit = c.maxHeader
 	return c.dec.Decode(h) // 通过ReadHeader 暴露 dec  *gob.Decoder 用于解码数据
 }
 
 func (c *GobCodec) ReadBody(body interface{}) error {
-	log.Println("GOB ReadBody")
+	c.r.limit = c.maxBody
 	return c.dec.Decode(body)
 }
 
@@ -55,17 +64,32 @@ func (c *GobCodec) Write(h *Header, body interface{}) (err error) {
 			_ = c.Close()
 		}
 	}()
-	if err := c.enc.Encode(h); err != nil {
-		log.Println("rpc codec:gob error encoding header:", err)
-		return err
+	if err = c.enc.Encode(h); err != nil {
+		return fmt.Errorf("rpc codec: gob error encoding header: %w", err)
 	}
 
-	if err := c.enc.Encode(body); err != nil {
-		log.Println("rpc codec:gob error encoding body:", err)
-		return err
+	if err = c.enc.Encode(body); err != nil {
+		return fmt.Errorf("rpc codec: gob error encoding body: %w", err)
 	}
 	return nil
 }
 func (c *GobCodec) Close() error {
 	return c.conn.Close()
 }
+
+// gobMarshaler 每个 body 使用独立的 Encoder，编码结果自带类型描述。
+// 不能按类型缓存 Encoder：gob 只在流中第一次出现某个类型时发送类型描述，而对端每个 body 都用新的 Decoder 解码，
+// 所以每个 body 都比 GobCodec 直接传输时多出类型描述，对小 body 敏感时可以改用 msgpack。
+type gobMarshaler struct{}
+
+func (gobMarshaler) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (gobMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
@@ -0,0 +1,169 @@
+package codec
+
+//消息大小限制，在解码之前按帧检查，避免对端用超大的长度前缀让本端分配大量内存
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// ErrMessageTooLarge header 或 body 超过了 SetMaxSizes 设置的上限。
+// 能按长度前缀整帧跳过的 Codec（gob、protobuf）跳过该帧后连接仍然可用；
+// 不能跳过时（msgpack，或者长度大到不值得读出丢弃）返回的错误同时匹配 ErrStreamCorrupted，调用方应关闭连接。
+var ErrMessageTooLarge = errors.New("rpc codec: message too large")
+
+// ErrStreamCorrupted 之前的帧超过大小限制或者格式错误，连接上的数据已经无法继续解析
+var ErrStreamCorrupted = errors.New("rpc codec: stream corrupted")
+
+// SizeLimiter 由支持大小限制的 Codec 实现，参数不大于 0 表示不限制。
+// 必须在开始读取之前调用。
+type SizeLimiter interface {
+	SetMaxSizes(maxHeader, maxBody int)
+}
+
+// maxSkip 超过大小限制的帧在这个长度以内时读出丢弃，保持连接可用；更长的视为格式错误
+const maxSkip = 1 << 30
+
+func tooLarge(size uint64, limit int) error {
+	return fmt.Errorf("%w: %d bytes exceeds limit %d", ErrMessageTooLarge, size, limit)
+}
+
+// gobReader 按 gob 的消息边界读取：每条消息开头是 gob 编码的长度，
+// 超过 limit 时在 gob 分配内存之前整条丢弃并返回 ErrMessageTooLarge，
+// gob.Decoder 不会消费任何字节，下一次 Decode 从下一条消息开始。
+// 它实现了 io.ByteReader，gob.NewDecoder 不会再包一层 bufio。
+type gobReader struct {
+	r         *bufio.Reader
+	limit     int
+	remaining int // 当前消息还没有交给 gob 的字节数，含长度前缀
+}
+
+func (g *gobReader) Read(p []byte) (int, error) {
+	if g.remaining == 0 {
+		if err := g.next(); err != nil {
+			return 0, err
+		}
+	}
+	if len(p) > g.remaining {
+		p = p[:g.remaining]
+	}
+	n, err := g.r.Read(p)
+	g.remaining -= n
+	return n, err
+}
+
+func (g *gobReader) ReadByte() (byte, error) {
+	var b [1]byte
+	if _, err := io.ReadFull(g, b[:]); err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// next 解析下一条消息的长度前缀：小于 0x80 的单字节就是长度，否则其相反数是随后大端长度的字节数
+func (g *gobReader) next() error {
+	b, err := g.r.Peek(1)
+	if err != nil {
+		return err
+	}
+	prefix, count := 1, uint64(b[0])
+	if b[0] >= 0x80 {
+		n := -int(int8(b[0]))
+		if n > 8 {
+			return fmt.Errorf("%w: invalid gob message length", ErrStreamCorrupted)
+		}
+		if b, err = g.r.Peek(1 + n); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return err
+		}
+		count = 0
+		for _, c := range b[1:] {
+			count = count<<8 | uint64(c)
+		}
+		prefix = 1 + n
+	}
+	if g.limit > 0 && count > uint64(g.limit) {
+		if count > maxSkip {
+			return fmt.Errorf("%w: %w", ErrStreamCorrupted, tooLarge(count, g.limit))
+		}
+		if _, err = g.r.Discard(prefix + int(count)); err != nil {
+			return err
+		}
+		return tooLarge(count, g.limit)
+	}
+	g.remaining = prefix + int(count)
+	return nil
+}
+
+// countingReader 统计解码一个值读取的字节数，超过 limit 时返回 ErrMessageTooLarge。
+// 用于没有长度前缀的格式，超限时值只读了一半，之后的读取都返回 ErrStreamCorrupted。
+type countingReader struct {
+	r     *bufio.Reader
+	limit int
+	n     int
+	err   error // 超限后不为 nil
+}
+
+// reset 开始读取新的值
+func (c *countingReader) reset(limit int) error {
+	if c.err != nil {
+		return ErrStreamCorrupted
+	}
+	c.limit, c.n = limit, 0
+	return nil
+}
+
+// wrap 超限时返回超限的错误，解码器可能会把读取时返回的错误替换掉
+func (c *countingReader) wrap(err error) error {
+	if err != nil && c.err != nil {
+		return c.err
+	}
+	return err
+}
+
+func (c *countingReader) check(n int) error {
+	c.n += n
+	if c.limit > 0 && c.n > c.limit {
+		c.err = fmt.Errorf("%w: %w", ErrStreamCorrupted, tooLarge(uint64(c.n), c.limit))
+		return c.err
+	}
+	return nil
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	if c.limit > 0 && len(p) > c.limit-c.n+1 {
+		// 多读一个字节即可判断超限
+		p = p[:c.limit-c.n+1]
+	}
+	n, err := c.r.Read(p)
+	if cerr := c.check(n); cerr != nil {
+		return n, cerr
+	}
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	b, err := c.r.ReadByte()
+	if err != nil {
+		return b, err
+	}
+	return b, c.check(1)
+}
+
+func (c *countingReader) UnreadByte() error {
+	if err := c.r.UnreadByte(); err != nil {
+		return err
+	}
+	c.n--
+	return nil
+}
@@ -0,0 +1,72 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+)
+
+type rwc struct {
+	io.Reader
+	io.Writer
+}
+
+func (rwc) Close() error { return nil }
+
+func TestGobCodec_MaxBodySkipsMessage(t *testing.T) {
+	var buf bytes.Buffer
+	w := NewGobCodec(rwc{Writer: &buf})
+	_ = w.Write(&Header{Seq: 1}, strings.Repeat("x", 4096))
+	_ = w.Write(&Header{Seq: 2}, "ok")
+
+	r := NewGobCodec(rwc{Reader: &buf})
+	r.(SizeLimiter).SetMaxSizes(0, 1024)
+	var h Header
+	var body string
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 1 {
+		t.Fatalf("read header: %+v %v", h, err)
+	}
+	if err := r.ReadBody(&body); !errors.Is(err, ErrMessageTooLarge) || errors.Is(err, ErrStreamCorrupted) {
+		t.Fatalf("expect skipped oversized body, got %v", err)
+	}
+	if err := r.ReadHeader(&h); err != nil || h.Seq != 2 {
+		t.Fatalf("read next header: %+v %v", h, err)
+	}
+	if err := r.ReadBody(&body); err != nil || body != "ok" {
+		t.Fatalf("read next body: %q %v", body, err)
+	}
+}
+
+func TestProtobufCodec_HugeLengthPrefix(t *testing.T) {
+	// 声明 1TB 的帧，不能按声明的长度分配内存
+	var frame [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(frame[:], 1<<40)
+	r := NewProtobufCodec(rwc{Reader: bytes.NewReader(frame[:n])})
+	r.(SizeLimiter).SetMaxSizes(1024, 1024)
+	var h Header
+	if err := r.ReadHeader(&h); !errors.Is(err, ErrMessageTooLarge) || !errors.Is(err, ErrStreamCorrupted) {
+		t.Fatalf("expect corrupted stream, got %v", err)
+	}
+}
+
+func TestProtobufCodec_HugeLengthPrefixWithoutLimits(t *testing.T) {
+	var frame [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(frame[:], 1<<62)
+	r := NewProtobufCodec(rwc{Reader: bytes.NewReader(frame[:n])})
+	r.(SizeLimiter).SetMaxSizes(-1, -1)
+	var h Header
+	if err := r.ReadHeader(&h); !errors.Is(err, ErrStreamCorrupted) {
+		t.Fatalf("expect corrupted stream, got %v", err)
+	}
+
+	// 长度在 maxSkip 以内但数据不足，按到达的数据分配，读到末尾时报错
+	n = binary.PutUvarint(frame[:], maxSkip)
+	r = NewProtobufCodec(rwc{Reader: io.MultiReader(bytes.NewReader(frame[:n]), strings.NewReader("short"))})
+	r.(SizeLimiter).SetMaxSizes(-1, -1)
+	if err := r.ReadHeader(&h); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expect unexpected EOF, got %v", err)
+	}
+}
@@ -0,0 +1,89 @@
+package codec
+
+//msgpack 协议，比 json 紧凑，也不像 gob 那样每个连接都要先交换类型描述
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+
+	"github.com/vmihailenco/msgpack/v5"
+)
+
+// MsgpackCodec 基于 github.com/vmihailenco/msgpack/v5，
+// 支持 `msgpack:"name,omitempty"` 结构体标签，time.Time 和 []byte 原生编码
+type MsgpackCodec struct {
+	conn io.ReadWriteCloser
+	buf  *bufio.Writer
+	dec  *msgpack.Decoder
+	enc  *msgpack.Encoder
+	r    *countingReader
+
+	maxHeader, maxBody int
+}
+
+var _ Codec = (*MsgpackCodec)(nil)
+
+func NewMsgpackCodec(conn io.ReadWriteCloser) Codec {
+	buf := bufio.NewWriter(conn)
+	r := &countingReader{r: bufio.NewReader(conn)}
+	return &MsgpackCodec{
+		conn: conn,
+		buf:  buf,
+		dec:  msgpack.NewDecoder(r),
+		enc:  msgpack.NewEncoder(buf),
+		r:    r,
+	}
+}
+
+// SetMaxSizes msgpack 没有长度前缀，按解码时实际读取的字节数检查，超限后连接无法继续使用
+func (c *MsgpackCodec) SetMaxSizes(maxHeader, maxBody int) {
+	c.maxHeader, c.maxBody = maxHeader, maxBody
+}
+
+func (c *MsgpackCodec) ReadHeader(h *Header) error {
+	*h = Header{}
+	if err := c.r.reset(c.maxHeader); err != nil {
+		return err
+	}
+	return c.r.wrap(c.dec.Decode(h))
+}
+
+// ReadBody body 为 nil 时跳过该值
+func (c *MsgpackCodec) ReadBody(body interface{}) error {
+	if err := c.r.reset(c.maxBody); err != nil {
+		return err
+	}
+	if body == nil {
+		return c.r.wrap(c.dec.Skip())
+	}
+	return c.r.wrap(c.dec.Decode(body))
+}
+
+func (c *MsgpackCodec) Write(h *Header, body interface{}) (err error) {
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.enc.Encode(h); err != nil {
+		return fmt.Errorf("rpc codec: msgpack error encoding header: %w", err)
+	}
+	if err = c.enc.Encode(body); err != nil {
+		return fmt.Errorf("rpc codec: msgpack error encoding body: %w", err)
+	}
+	return nil
+}
+
+func (c *MsgpackCodec) Close() error {
+	return c.conn.Close()
+}
+
+type msgpackMarshaler struct{}
+
+func (msgpackMarshaler) Marshal(v interface{}) ([]byte, error) { return msgpack.Marshal(v) }
+
+func (msgpackMarshaler) Unmarshal(data []byte, v interface{}) error {
+	return msgpack.Unmarshal(data, v)
+}
@@ -0,0 +1,110 @@
+package codec
+
+import (
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+type tagged struct {
+	Name    string    `msgpack:"n"`
+	Skipped string    `msgpack:"-"`
+	Empty   int       `msgpack:"e,omitempty"`
+	At      time.Time `msgpack:"at"`
+	Raw     []byte    `msgpack:"raw"`
+}
+
+func TestMsgpackCodec_RoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	client, server := NewMsgpackCodec(a), NewMsgpackCodec(b)
+	defer func() { _ = client.Close(); _ = server.Close() }()
+
+	at := time.Date(2023, 5, 1, 12, 0, 0, 123, time.UTC)
+	want := tagged{Name: "fancy", Skipped: "x", At: at, Raw: []byte{0, 1, 2}}
+	go func() { _ = client.Write(&Header{ServiceMethod: "Foo.Sum", Seq: 7}, &want) }()
+
+	var h Header
+	if err := server.ReadHeader(&h); err != nil || h.ServiceMethod != "Foo.Sum" || h.Seq != 7 {
+		t.Fatalf("header mismatch: %+v %v", h, err)
+	}
+	var got tagged
+	if err := server.ReadBody(&got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "fancy" || got.Skipped != "" || !got.At.Equal(at) || !bytes.Equal(got.Raw, want.Raw) {
+		t.Fatalf("body mismatch: %+v", got)
+	}
+
+	// 出错时的空 body 可以被跳过，后续的帧不受影响
+	go func() {
+		_ = server.Write(&Header{Seq: 7, Error: "boom"}, struct{}{})
+		_ = server.Write(&Header{Seq: 8}, 3)
+	}()
+	if err := client.ReadHeader(&h); err != nil || h.Error != "boom" {
+		t.Fatalf("error header mismatch: %+v %v", h, err)
+	}
+	if err := client.ReadBody(nil); err != nil {
+		t.Fatal(err)
+	}
+	var n int
+	if err := client.ReadHeader(&h); err != nil || h.Seq != 8 || h.Error != "" {
+		t.Fatalf("header mismatch: %+v %v", h, err)
+	}
+	if err := client.ReadBody(&n); err != nil || n != 3 {
+		t.Fatalf("body mismatch: %d %v", n, err)
+	}
+}
+
+// loopback 把写入的字节原样读回，用于在单个 goroutine 里做编解码基准
+type loopback struct{ bytes.Buffer }
+
+func (*loopback) Close() error { return nil }
+
+type sumArgs struct{ Num1, Num2 int }
+
+// benchmarkSteady 同一个连接上反复收发 Foo.Sum 风格的请求
+func benchmarkSteady(b *testing.B, f NewCodecFunc) {
+	cc := f(&loopback{})
+	h := &Header{ServiceMethod: "Foo.Sum"}
+	var rh Header
+	var args sumArgs
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		h.Seq = uint64(i)
+		if err := cc.Write(h, &sumArgs{Num1: i, Num2: i + i}); err != nil {
+			b.Fatal(err)
+		}
+		if err := cc.ReadHeader(&rh); err != nil {
+			b.Fatal(err)
+		}
+		if err := cc.ReadBody(&args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// benchmarkNewConn 每次都新建 codec，体现 gob 每个连接交换类型描述的预热开销
+func benchmarkNewConn(b *testing.B, f NewCodecFunc) {
+	h := &Header{ServiceMethod: "Foo.Sum", Seq: 1}
+	var rh Header
+	var args sumArgs
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		cc := f(&loopback{})
+		if err := cc.Write(h, &sumArgs{Num1: i, Num2: i + i}); err != nil {
+			b.Fatal(err)
+		}
+		if err := cc.ReadHeader(&rh); err != nil {
+			b.Fatal(err)
+		}
+		if err := cc.ReadBody(&args); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGobCodec_Steady(b *testing.B)      { benchmarkSteady(b, NewGobCodec) }
+func BenchmarkMsgpackCodec_Steady(b *testing.B)  { benchmarkSteady(b, NewMsgpackCodec) }
+func BenchmarkGobCodec_NewConn(b *testing.B)     { benchmarkNewConn(b, NewGobCodec) }
+func BenchmarkMsgpackCodec_NewConn(b *testing.B) { benchmarkNewConn(b, NewMsgpackCodec) }
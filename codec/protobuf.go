@@ -0,0 +1,341 @@
+package codec
+
+//protobuf 协议，方便与其他语言的服务互通
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+)
+
+// ProtobufCodec 每个 header 和 body 都是一个长度前缀的帧：uvarint(len) + bytes，
+// 与 protobuf 官方的 writeDelimitedTo/parseDelimitedFrom 一致。
+// header 按 header.proto 中固定的 schema 编码，body 必须实现 proto.Message。
+type ProtobufCodec struct {
+	conn io.ReadWriteCloser
+	r    *bufio.Reader
+	buf  *bufio.Writer
+
+	maxHeader, maxBody int
+}
+
+var _ Codec = (*ProtobufCodec)(nil)
+
+func NewProtobufCodec(conn io.ReadWriteCloser) Codec {
+	return &ProtobufCodec{
+		conn: conn,
+		r:    bufio.NewReader(conn),
+		buf:  bufio.NewWriter(conn),
+	}
+}
+
+// header.proto 中的字段编号，只能新增，不能修改
+const (
+	pbHeaderServiceMethod protowire.Number = 1
+	pbHeaderSeq           protowire.Number = 2
+	pbHeaderError         protowire.Number = 3
+	pbHeaderTraceParent   protowire.Number = 4
+	pbHeaderCompression   protowire.Number = 5
+	pbHeaderType          protowire.Number = 6
+	pbHeaderWindow        protowire.Number = 7
+	pbHeaderReverse       protowire.Number = 8
+	pbHeaderMetadata      protowire.Number = 9
+	pbHeaderCode          protowire.Number = 10
+	pbHeaderErrorDetails  protowire.Number = 11
+)
+
+func marshalPbHeader(h *Header) []byte {
+	var b []byte
+	if h.ServiceMethod != "" {
+		b = protowire.AppendTag(b, pbHeaderServiceMethod, protowire.BytesType)
+		b = protowire.AppendString(b, h.ServiceMethod)
+	}
+	if h.Seq != 0 {
+		b = protowire.AppendTag(b, pbHeaderSeq, protowire.VarintType)
+		b = protowire.AppendVarint(b, h.Seq)
+	}
+	if h.Error != "" {
+		b = protowire.AppendTag(b, pbHeaderError, protowire.BytesType)
+		b = protowire.AppendString(b, h.Error)
+	}
+	if h.TraceParent != "" {
+		b = protowire.AppendTag(b, pbHeaderTraceParent, protowire.BytesType)
+		b = protowire.AppendString(b, h.TraceParent)
+	}
+	if h.Compression != "" {
+		b = protowire.AppendTag(b, pbHeaderCompression, protowire.BytesType)
+		b = protowire.AppendString(b, h.Compression)
+	}
+	if h.Type != FrameCall {
+		b = protowire.AppendTag(b, pbHeaderType, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Type))
+	}
+	if h.Window != 0 {
+		b = protowire.AppendTag(b, pbHeaderWindow, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Window))
+	}
+	if h.Reverse {
+		b = protowire.AppendTag(b, pbHeaderReverse, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeBool(true))
+	}
+	b = appendPbMap(b, pbHeaderMetadata, h.Metadata)
+	if h.Code != 0 {
+		b = protowire.AppendTag(b, pbHeaderCode, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(h.Code))
+	}
+	b = appendPbMap(b, pbHeaderErrorDetails, h.ErrorDetails)
+	return b
+}
+
+// appendPbMap map 按 proto3 的规则编码为重复的 entry 消息：key = 1，value = 2，按 key 排序保证输出稳定
+func appendPbMap(b []byte, num protowire.Number, m map[string]string) []byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		var entry []byte
+		entry = protowire.AppendTag(entry, 1, protowire.BytesType)
+		entry = protowire.AppendString(entry, k)
+		entry = protowire.AppendTag(entry, 2, protowire.BytesType)
+		entry = protowire.AppendString(entry, m[k])
+		b = protowire.AppendTag(b, num, protowire.BytesType)
+		b = protowire.AppendBytes(b, entry)
+	}
+	return b
+}
+
+func unmarshalPbHeader(b []byte, h *Header) error {
+	*h = Header{}
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == pbHeaderServiceMethod && typ == protowire.BytesType:
+			h.ServiceMethod, n = protowire.ConsumeString(b)
+		case num == pbHeaderSeq && typ == protowire.VarintType:
+			h.Seq, n = protowire.ConsumeVarint(b)
+		case num == pbHeaderError && typ == protowire.BytesType:
+			h.Error, n = protowire.ConsumeString(b)
+		case num == pbHeaderTraceParent && typ == protowire.BytesType:
+			h.TraceParent, n = protowire.ConsumeString(b)
+		case num == pbHeaderCompression && typ == protowire.BytesType:
+			h.Compression, n = protowire.ConsumeString(b)
+		case num == pbHeaderType && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			h.Type = FrameType(v)
+		case num == pbHeaderWindow && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			h.Window = uint32(v)
+		case num == pbHeaderReverse && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			h.Reverse = protowire.DecodeBool(v)
+		case num == pbHeaderMetadata && typ == protowire.BytesType:
+			var entry []byte
+			if entry, n = protowire.ConsumeBytes(b); n >= 0 {
+				if err := unmarshalPbMapEntry(entry, &h.Metadata); err != nil {
+					return err
+				}
+			}
+		case num == pbHeaderCode && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			h.Code = uint32(v)
+		case num == pbHeaderErrorDetails && typ == protowire.BytesType:
+			var entry []byte
+			if entry, n = protowire.ConsumeBytes(b); n >= 0 {
+				if err := unmarshalPbMapEntry(entry, &h.ErrorDetails); err != nil {
+					return err
+				}
+			}
+		default:
+			// 未知字段直接跳过，保证新老版本可以互通
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func unmarshalPbMapEntry(b []byte, m *map[string]string) error {
+	var key, value string
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			key, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.BytesType:
+			value, n = protowire.ConsumeString(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	if *m == nil {
+		*m = make(map[string]string)
+	}
+	(*m)[key] = value
+	return nil
+}
+
+// SetMaxSizes 按帧的长度前缀检查，超限的帧读出丢弃，连接仍然可用
+func (c *ProtobufCodec) SetMaxSizes(maxHeader, maxBody int) {
+	c.maxHeader, c.maxBody = maxHeader, maxBody
+}
+
+func (c *ProtobufCodec) readFrame(limit int) ([]byte, error) {
+	size, err := binary.ReadUvarint(c.r)
+	if err != nil {
+		return nil, err
+	}
+	if limit > 0 && size > uint64(limit) {
+		if size > maxSkip {
+			return nil, fmt.Errorf("%w: %w", ErrStreamCorrupted, tooLarge(size, limit))
+		}
+		if _, err = c.r.Discard(int(size)); err != nil {
+			return nil, err
+		}
+		return nil, tooLarge(size, limit)
+	}
+	// 不限制大小时长度前缀同样来自对端，不能按它直接分配
+	if size > maxSkip {
+		return nil, fmt.Errorf("%w: protobuf frame length %d exceeds %d", ErrStreamCorrupted, size, maxSkip)
+	}
+	if size <= pbPrealloc {
+		frame := make([]byte, size)
+		if _, err = io.ReadFull(c.r, frame); err != nil {
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return nil, err
+		}
+		return frame, nil
+	}
+	// 大的帧随数据到达逐步扩容，伪造的长度只会读到连接关闭
+	var buf bytes.Buffer
+	if _, err = io.CopyN(&buf, c.r, int64(size)); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// pbPrealloc 不超过这个长度的帧按长度前缀一次分配
+const pbPrealloc = 64 << 10
+
+func (c *ProtobufCodec) writeFrame(b []byte) error {
+	var size [binary.MaxVarintLen64]byte
+	if _, err := c.buf.Write(size[:binary.PutUvarint(size[:], uint64(len(b)))]); err != nil {
+		return err
+	}
+	_, err := c.buf.Write(b)
+	return err
+}
+
+func (c *ProtobufCodec) ReadHeader(h *Header) error {
+	frame, err := c.readFrame(c.maxHeader)
+	if err != nil {
+		return err
+	}
+	return unmarshalPbHeader(frame, h)
+}
+
+// ReadBody body 为 nil 时丢弃该帧，*[]byte 时原样返回帧内容
+func (c *ProtobufCodec) ReadBody(body interface{}) error {
+	frame, err := c.readFrame(c.maxBody)
+	if err != nil {
+		return err
+	}
+	if body == nil {
+		return nil
+	}
+	return unmarshalPbBody(frame, body)
+}
+
+func unmarshalPbBody(b []byte, body interface{}) error {
+	switch v := body.(type) {
+	case proto.Message:
+		return proto.Unmarshal(b, v)
+	case *[]byte:
+		*v = b
+		return nil
+	default:
+		return fmt.Errorf("rpc codec: protobuf body %T does not implement proto.Message", body)
+	}
+}
+
+var errPbBody = fmt.Errorf("%w: protobuf body must implement proto.Message", ErrMarshalBody)
+
+func marshalPbBody(body interface{}) ([]byte, error) {
+	switch v := body.(type) {
+	case nil, struct{}:
+		// 服务端出错时回复的空 body
+		return nil, nil
+	case proto.Message:
+		b, err := proto.Marshal(v)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrMarshalBody, err)
+		}
+		return b, nil
+	case []byte:
+		return v, nil
+	default:
+		return nil, errPbBody
+	}
+}
+
+func (c *ProtobufCodec) Write(h *Header, body interface{}) (err error) {
+	// body 先编码，失败时不写出任何字节，连接上的帧保持完整，也不必关闭连接
+	b, err := marshalPbBody(body)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = c.buf.Flush()
+		if err != nil {
+			_ = c.Close()
+		}
+	}()
+	if err = c.writeFrame(marshalPbHeader(h)); err != nil {
+		return fmt.Errorf("rpc codec: protobuf error writing header: %w", err)
+	}
+	if err = c.writeFrame(b); err != nil {
+		return fmt.Errorf("rpc codec: protobuf error writing body: %w", err)
+	}
+	return nil
+}
+
+func (c *ProtobufCodec) Close() error {
+	return c.conn.Close()
+}
+
+type protobufMarshaler struct{}
+
+func (protobufMarshaler) Marshal(v interface{}) ([]byte, error) { return marshalPbBody(v) }
+
+func (protobufMarshaler) Unmarshal(data []byte, v interface{}) error { return unmarshalPbBody(data, v) }
@@ -0,0 +1,57 @@
+package codec
+
+import (
+	"net"
+	"reflect"
+	"testing"
+
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestProtobufCodec_RoundTrip(t *testing.T) {
+	a, b := net.Pipe()
+	client, server := NewProtobufCodec(a), NewProtobufCodec(b)
+	defer func() { _ = client.Close(); _ = server.Close() }()
+
+	want := Header{ServiceMethod: "Echo.Upper", Seq: 42, TraceParent: "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		Type: FrameWindow, Window: 8, Reverse: true, Metadata: map[string]string{"tenant": "acme", "token": ""},
+		Error: "boom", Code: 5, ErrorDetails: map[string]string{"field": "name"}}
+	go func() { _ = client.Write(&want, wrapperspb.String("hello")) }()
+
+	var h Header
+	if err := server.ReadHeader(&h); err != nil || !reflect.DeepEqual(h, want) {
+		t.Fatalf("header mismatch: %+v %v", h, err)
+	}
+	var body wrapperspb.StringValue
+	if err := server.ReadBody(&body); err != nil || body.GetValue() != "hello" {
+		t.Fatalf("body mismatch: %q %v", body.GetValue(), err)
+	}
+
+	// 服务端出错时的空 body，对端可以直接丢弃
+	go func() { _ = server.Write(&Header{Seq: 42, Error: "boom"}, struct{}{}) }()
+	if err := client.ReadHeader(&h); err != nil || h.Error != "boom" || h.Seq != 42 {
+		t.Fatalf("error header mismatch: %+v %v", h, err)
+	}
+	if err := client.ReadBody(nil); err != nil {
+		t.Fatalf("discard body: %v", err)
+	}
+}
+
+func TestProtobufCodec_RejectsNonProtoBody(t *testing.T) {
+	a, b := net.Pipe()
+	defer func() { _ = a.Close(); _ = b.Close() }()
+	if err := NewProtobufCodec(a).Write(&Header{}, 1); err != errPbBody {
+		t.Fatalf("expect errPbBody, got %v", err)
+	}
+}
+
+func TestProtobufHeader_SkipsUnknownFields(t *testing.T) {
+	b := marshalPbHeader(&Header{ServiceMethod: "Foo.Sum", Seq: 1})
+	b = protowire.AppendTag(b, 99, protowire.BytesType)
+	b = protowire.AppendString(b, "future")
+	var h Header
+	if err := unmarshalPbHeader(b, &h); err != nil || h.ServiceMethod != "Foo.Sum" || h.Seq != 1 {
+		t.Fatalf("unexpected header %+v %v", h, err)
+	}
+}
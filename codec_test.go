@@ -0,0 +1,101 @@
+package FancyRPC
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"FancyRPC/codec"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Echo struct{}
+
+func (Echo) Upper(args *wrapperspb.StringValue, reply *wrapperspb.StringValue) error {
+	reply.Value = strings.ToUpper(args.GetValue())
+	return nil
+}
+
+// Len 的回复不是 proto.Message，protobuf 编码下无法发送
+type Len struct{}
+
+func (Len) Of(args *wrapperspb.StringValue, reply *int) error {
+	*reply = len(args.GetValue())
+	return nil
+}
+
+func TestProtobufCodec_EndToEnd(t *testing.T) {
+	server := NewServer()
+	_assert(server.Register(Echo{}) == nil && server.Register(Len{}) == nil, "register failed")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{CodecType: codec.ProtobufType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	reply := new(wrapperspb.StringValue)
+	err = client.Call("Echo.Upper", wrapperspb.String("fancy"), reply)
+	_assert(err == nil && reply.GetValue() == "FANCY", "unexpected reply %q %v", reply.GetValue(), err)
+
+	err = client.Call("Echo.Lower", wrapperspb.String("fancy"), reply)
+	_assert(err != nil && strings.Contains(err.Error(), "Lower"), "expect method not found, got %v", err)
+
+	// 回复无法编码时返回 Internal 错误，连接仍然可用
+	for _, opt := range []*Option{{CodecType: codec.ProtobufType}, {CodecType: codec.ProtobufType, Compression: "gzip"}} {
+		c, err := Dial("tcp", l.Addr().String(), opt)
+		_assert(err == nil, "dial failed: %v", err)
+		err = c.Call("Len.Of", wrapperspb.String("fancy"), reply)
+		_assert(CodeOf(err) == Internal && strings.Contains(err.Error(), "proto.Message"), "expect internal error, got %v", err)
+		err = c.Call("Echo.Upper", wrapperspb.String("again"), reply)
+		_assert(err == nil && reply.GetValue() == "AGAIN", "unexpected reply %q %v", reply.GetValue(), err)
+		_ = c.Close()
+	}
+}
+
+func TestMsgpackCodec_EndToEnd(t *testing.T) {
+	_, addr := startTestServer(t)
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.MsgpackType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Foo.Sum", &Args{Num1: 20, Num2: 22}, &reply)
+	_assert(err == nil && reply == 42, "unexpected reply %d %v", reply, err)
+}
+
+type Blob struct{}
+
+type RepeatArgs struct {
+	S string
+	N int
+}
+
+func (Blob) Repeat(args RepeatArgs, reply *string) error {
+	*reply = strings.Repeat(args.S, args.N)
+	return nil
+}
+
+func TestCompression_EndToEnd(t *testing.T) {
+	m := NewMetrics()
+	server := NewServer(WithMetrics(m))
+	_assert(server.Register(Blob{}) == nil, "register Blob failed")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{Compression: codec.GzipCompression})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call("Blob.Repeat", RepeatArgs{S: "fancy", N: 10000}, &reply)
+	_assert(err == nil && reply == strings.Repeat("fancy", 10000), "unexpected reply len %d %v", len(reply), err)
+	written := m.family("fancyrpc_server_written_bytes_total", "", kindCounter, nil).with().Value()
+	_assert(written > 0 && written < 5000, "expect compressed reply, server wrote %v bytes", written)
+
+	_, err = Dial("tcp", l.Addr().String(), &Option{Compression: "lz4"})
+	_assert(err != nil, "expect unknown compression to be rejected")
+}
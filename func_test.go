@@ -0,0 +1,119 @@
+package FancyRPC
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRegisterFunc(t *testing.T) {
+	server := NewServer(WithReflection())
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	var counter atomic.Int64
+	_assert(server.RegisterFunc("Math.Add", func(args Args, reply *int) error {
+		*reply = args.Num1 + args.Num2
+		return nil
+	}) == nil, "register Math.Add failed")
+	// 闭包可以持有状态，带 context 的函数可以读取 metadata
+	_assert(server.RegisterFunc("Math.Incr", func(ctx context.Context, n int64, reply *int64) error {
+		*reply = counter.Add(n)
+		return nil
+	}) == nil, "register Math.Incr failed")
+	_assert(server.RegisterFunc("Math.Count", func(n int, stream *ServerStream[int]) error {
+		for i := 0; i < n; i++ {
+			if err := stream.Send(i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}) == nil, "register Math.Count failed")
+
+	errCases := []struct {
+		name string
+		fn   interface{}
+		err  string
+	}{
+		{"Math.Add", func(args Args, reply *int) error { return nil }, "method already defined: Math.Add"},
+		{"Foo.Mul", func(args Args, reply *int) error { return nil }, "service already defined: Foo"},
+		{"Math", func(args Args, reply *int) error { return nil }, "not a valid Service.Method name"},
+		{"Math.sub", func(args Args, reply *int) error { return nil }, "not a valid Service.Method name"},
+		{"Math.Sub", 42, "must be a function, got int"},
+		{"Math.Sub", func(args Args, reply int) error { return nil }, "invalid signature"},
+		{"Math.Sub", func(args Args, reply *int) {}, "invalid signature"},
+		{"Math.Sub", func(args unexportedArgs, reply *int) error { return nil }, "invalid signature"},
+		{"Math.Sub", (func(args Args, reply *int) error)(nil), "must not be a nil function"},
+	}
+	for _, c := range errCases {
+		err := server.RegisterFunc(c.name, c.fn)
+		_assert(err != nil && strings.Contains(err.Error(), c.err), "%s: expect error %q, got %v", c.name, c.err, err)
+	}
+
+	// 只有 RegisterFunc 要求 reply 是指针，Register 的规则不变
+	s, err := newService(ValueReply{})
+	_assert(err == nil && s.method["Get"] != nil, "Register should accept a non-pointer reply")
+
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String())
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	sum, err := Invoke[Args, int](ctx, client, "Math.Add", Args{Num1: 1, Num2: 2})
+	_assert(err == nil && sum == 3, "unexpected sum %d %v", sum, err)
+	n, err := Invoke[int64, int64](ctx, client, "Math.Incr", 5)
+	_assert(err == nil && n == 5, "unexpected counter %d %v", n, err)
+	stream, err := client.Stream(ctx, "Math.Count", 3, new(int))
+	_assert(err == nil, "stream failed: %v", err)
+	var got []int
+	for {
+		var i int
+		if stream.Recv(&i) != nil {
+			break
+		}
+		got = append(got, i)
+	}
+	_assert(fmt.Sprint(got) == "[0 1 2]", "unexpected stream %v", got)
+
+	var services []ServiceDesc
+	err = client.CallContext(ctx, "Reflection.ListServices", "Math", &services)
+	_assert(err == nil && len(services) == 1 && len(services[0].Methods) == 3 && services[0].Methods[0].Name == "Add",
+		"unexpected services %+v %v", services, err)
+
+	// 服务运行中注册新的函数，与正在进行的调用并发
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			err := server.RegisterFunc(fmt.Sprintf("Math.Const%d", i), func(_ int, reply *int) error {
+				*reply = i
+				return nil
+			})
+			_assert(err == nil, "register failed: %v", err)
+		}(i)
+		go func() {
+			defer wg.Done()
+			sum, err := Invoke[Args, int](ctx, client, "Math.Add", Args{Num1: 2, Num2: 2})
+			_assert(err == nil && sum == 4, "unexpected sum %d %v", sum, err)
+		}()
+	}
+	wg.Wait()
+	c7, err := Invoke[int, int](ctx, client, "Math.Const7", 0)
+	_assert(err == nil && c7 == 7, "unexpected const %d %v", c7, err)
+}
+
+type unexportedArgs struct{}
+
+type ValueReply struct{}
+
+func (ValueReply) Get(args int, reply map[string]int) error {
+	reply["n"] = args
+	return nil
+}
@@ -0,0 +1,106 @@
+package FancyRPC
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// REST 网关：每个注册的方法对应 POST {prefix}{Service}/{Method}，
+// 请求体是参数的 JSON，为空时参数为零值；成功时回复 200 和 reply 的 JSON，
+// 失败时按错误码映射为 HTTP 状态码，回复体为 {"code","message","details"}。
+
+// DefaultGatewayPrefix Gateway 的 prefix 为空时使用
+const DefaultGatewayPrefix = "/rpc/"
+
+type gatewayError struct {
+	Code    string            `json:"code"`
+	Message string            `json:"message"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+// Gateway 返回 REST 网关，prefix 形如 "/rpc/"，例如 mux.Handle("/rpc/", server.Gateway(""))
+func (server *Server) Gateway(prefix string) http.Handler {
+	if prefix == "" {
+		prefix = DefaultGatewayPrefix
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		server.serveGateway(w, r, prefix)
+	})
+}
+
+func (server *Server) serveGateway(w http.ResponseWriter, r *http.Request, prefix string) {
+	path, ok := strings.CutPrefix(r.URL.Path, prefix)
+	svc, method, found := strings.Cut(path, "/")
+	if !ok || !found || svc == "" || method == "" || strings.Contains(method, "/") {
+		writeGatewayError(w, Errorf(NotFound, "rpc gateway: path must be %s{Service}/{Method}", prefix))
+		return
+	}
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGatewayStatus(w, http.StatusMethodNotAllowed, Errorf(InvalidArgument, "rpc gateway: method %s not allowed", r.Method))
+		return
+	}
+	data, err := server.readHTTPBody(w, r)
+	if err != nil {
+		st := statusWithDefault(err, InvalidArgument)
+		if st.Code == ResourceExhausted {
+			writeGatewayStatus(w, http.StatusRequestEntityTooLarge, st)
+			return
+		}
+		writeGatewayError(w, st)
+		return
+	}
+	data = bytes.TrimSpace(data)
+	reply, _, err := server.invokeHTTP(r, httpMetadata(r), svc+"."+method, func(argv reflect.Value) error {
+		return decodeJSONArg(data, argv)
+	})
+	if err != nil {
+		writeGatewayError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+func writeGatewayError(w http.ResponseWriter, err error) {
+	writeGatewayStatus(w, httpStatus(CodeOf(err)), err)
+}
+
+func writeGatewayStatus(w http.ResponseWriter, status int, err error) {
+	st := statusOf(err)
+	writeJSON(w, status, gatewayError{Code: st.Code.String(), Message: st.Message, Details: st.Details})
+}
+
+// httpStatus 错误码对应的 HTTP 状态码，与 gRPC 网关的约定一致
+func httpStatus(c Code) int {
+	switch c {
+	case OK:
+		return http.StatusOK
+	case Canceled:
+		return 499 // 客户端关闭了请求，沿用 nginx 的约定
+	case InvalidArgument, FailedPrecondition, OutOfRange:
+		return http.StatusBadRequest
+	case DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case NotFound:
+		return http.StatusNotFound
+	case AlreadyExists, Aborted:
+		return http.StatusConflict
+	case PermissionDenied:
+		return http.StatusForbidden
+	case Unauthenticated:
+		return http.StatusUnauthorized
+	case ResourceExhausted:
+		return http.StatusTooManyRequests
+	case Unimplemented:
+		return http.StatusNotImplemented
+	case Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,52 @@
+package FancyRPC
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestGateway(t *testing.T) {
+	server := NewServer(WithMaxMessageSize(0, 64))
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	_assert(server.Register(Users{}) == nil, "register Users failed")
+	_assert(server.Register(Rows{}) == nil, "register Rows failed")
+	mux := http.NewServeMux()
+	mux.Handle("/rpc/", server.Gateway(""))
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+
+	cases := []struct {
+		method, path, body string
+		status             int
+		resp               string
+	}{
+		{"POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2}`, 200, `3`},
+		{"POST", "/rpc/Users/Get", `1`, 200, `"alice"`},
+		{"POST", "/rpc/Users/Get", `2`, 404, `{"code":"NotFound","message":"user 2 not found","details":{"id":"2"}}`},
+		{"POST", "/rpc/Users/Get", `3`, 504, `{"code":"DeadlineExceeded","message":"lookup user 3: context deadline exceeded"}`},
+		{"POST", "/rpc/Users/Get", `4`, 500, `{"code":"Unknown","message":"database is on fire"}`},
+		{"POST", "/rpc/Users/Get", `"x"`, 400, `{"code":"InvalidArgument","message":"rpc server: invalid params: json: cannot unmarshal string into Go value of type int"}`},
+		{"POST", "/rpc/Users/Get", ``, 500, `{"code":"Unknown","message":"database is on fire"}`},
+		{"POST", "/rpc/Foo/Nope", `{}`, 404, `{"code":"NotFound","message":"rpc server: can't find method Nope"}`},
+		{"POST", "/rpc/Foo", `{}`, 404, `{"code":"NotFound","message":"rpc gateway: path must be /rpc/{Service}/{Method}"}`},
+		{"POST", "/rpc/Rows/List", `1`, 501, `{"code":"Unimplemented","message":"rpc server: streaming method Rows.List cannot be called over HTTP"}`},
+		{"GET", "/rpc/Foo/Sum", ``, 405, `{"code":"InvalidArgument","message":"rpc gateway: method GET not allowed"}`},
+		{"POST", "/rpc/Foo/Sum", `{"Num1":1,"Num2":2,"Padding":"` + strings.Repeat("x", 64) + `"}`, 413,
+			`{"code":"ResourceExhausted","message":"rpc server: read request body: http: request body too large"}`},
+	}
+	for _, c := range cases {
+		req, _ := http.NewRequest(c.method, ts.URL+c.path, strings.NewReader(c.body))
+		resp, err := http.DefaultClient.Do(req)
+		_assert(err == nil, "%s %s failed: %v", c.method, c.path, err)
+		b, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		got := strings.TrimSpace(string(b))
+		_assert(resp.StatusCode == c.status && got == c.resp, "%s %s %s\n got %d %s\nwant %d %s",
+			c.method, c.path, c.body, resp.StatusCode, got, c.status, c.resp)
+		_assert(resp.Header.Get("Content-Type") == "application/json", "unexpected content type %q", resp.Header.Get("Content-Type"))
+	}
+}
@@ -1,3 +1,12 @@
 module FancyRPC
 
-go 1.20
+go 1.21
+
+require (
+	github.com/golang/snappy v0.0.4
+	github.com/gorilla/websocket v1.5.3
+	github.com/vmihailenco/msgpack/v5 v5.4.1
+	google.golang.org/protobuf v1.36.5
+)
+
+require github.com/vmihailenco/tagparser/v2 v2.0.0 // indirect
@@ -0,0 +1,124 @@
+package FancyRPC
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"FancyRPC/codec"
+)
+
+// JSON-RPC 和 REST 网关共用的 HTTP 调用路径：认证、限流、并发限制、指标和追踪与 TCP 上的请求相同。
+
+// readHTTPBody 读取请求体，超过 body 大小限制时返回 ResourceExhausted 错误
+func (server *Server) readHTTPBody(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	body := r.Body
+	if limit := server.maxBodySize; limit >= 0 {
+		if limit == 0 {
+			limit = DefaultMaxBodySize
+		}
+		body = http.MaxBytesReader(w, r.Body, int64(limit))
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			return nil, messageTooLarge("rpc server: read request body", err)
+		}
+		return nil, err
+	}
+	return data, nil
+}
+
+// httpMetadata HTTP 请求头以小写的键作为 metadata，同名的头只取第一个值
+func httpMetadata(r *http.Request) Metadata {
+	md := make(Metadata, len(r.Header))
+	for k, v := range r.Header {
+		md[strings.ToLower(k)] = v[0]
+	}
+	return md
+}
+
+// invokeHTTP 执行一次 HTTP 上的调用，decode 把请求中的参数解码到 argv。
+// 出错时同时返回对应的 JSON-RPC 错误码，REST 网关只使用错误本身的错误码。
+func (server *Server) invokeHTTP(r *http.Request, md Metadata, serviceMethod string, decode func(argv reflect.Value) error) (interface{}, int, error) {
+	info := &RequestInfo{ServiceMethod: serviceMethod, RemoteAddr: r.RemoteAddr, Metadata: md}
+	ctx, err := server.admit(r.Context(), info)
+	if err != nil {
+		if server.metrics != nil {
+			if err == ErrQuotaExceeded {
+				server.metrics.rateLimited.with(serviceMethod).Inc()
+			} else {
+				server.metrics.invalid.Inc()
+			}
+		}
+		return nil, jsonrpcServerError, statusWithDefault(err, Unauthenticated)
+	}
+	svc, mtype, err := server.findService(serviceMethod)
+	if err != nil {
+		return nil, jsonrpcMethodNotFound, err
+	}
+	if mtype.clientStream || mtype.serverStream {
+		return nil, jsonrpcMethodNotFound, Errorf(Unimplemented, "rpc server: streaming method %s cannot be called over HTTP", serviceMethod)
+	}
+	argv, replyv := mtype.newArgv(), mtype.newReplyv()
+	if err := decode(argv); err != nil {
+		if server.metrics != nil {
+			server.metrics.invalid.Inc()
+		}
+		return nil, jsonrpcInvalidParams, Errorf(InvalidArgument, "rpc server: invalid params: %v", err)
+	}
+
+	acquire, err := server.limits.admit(serviceMethod)
+	if err != nil {
+		return nil, jsonrpcServerError, server.rejectHTTP(serviceMethod, err)
+	}
+	release, err := acquire(ctx)
+	if err != nil {
+		return nil, jsonrpcServerError, server.rejectHTTP(serviceMethod, err)
+	}
+	finish := server.metrics.begin(serviceMethod)
+	span := server.startSpan(&codec.Header{ServiceMethod: serviceMethod, TraceParent: r.Header.Get("traceparent")})
+	if span != nil {
+		ctx = ContextWithSpan(ctx, span)
+	}
+	err = svc.call(ctx, mtype, argv, replyv)
+	release()
+	finish(err)
+	if span != nil {
+		span.End(err)
+	}
+	if err != nil {
+		return nil, jsonrpcServerError, err
+	}
+	return replyv.Interface(), 0, nil
+}
+
+func (server *Server) rejectHTTP(serviceMethod string, err error) error {
+	if server.metrics != nil {
+		server.metrics.rejected.with(serviceMethod).Inc()
+	}
+	server.logger.Log(LevelWarn, "rpc server: request rejected", F(FieldServiceMethod, serviceMethod), F(FieldError, err))
+	return err
+}
+
+// decodeJSONArg 把 JSON 解码到参数中，空的 data 表示参数为零值
+func decodeJSONArg(data []byte, argv reflect.Value) error {
+	if len(data) == 0 {
+		return nil
+	}
+	target := argv.Interface()
+	if argv.Kind() != reflect.Ptr {
+		target = argv.Addr().Interface()
+	}
+	return json.Unmarshal(data, target)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,194 @@
+package FancyRPC
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync"
+)
+
+// JSON-RPC 2.0 over HTTP POST：method 为 "Service.Method"，直接调用已经注册的方法。
+// params 为对象时解码为方法的参数，为数组时只能有一个元素，即参数本身；没有 params 时参数为零值。
+// 支持批量请求和通知（没有 id 的请求不回复），全部是通知时回复 204。
+// HTTP 请求头以小写的键作为 metadata 交给 Authenticator 和 RateLimiter。
+// 流式方法不能通过 JSON-RPC 调用。
+
+// JSON-RPC 2.0 规定的错误码
+const (
+	jsonrpcParseError     = -32700
+	jsonrpcInvalidRequest = -32600
+	jsonrpcMethodNotFound = -32601
+	jsonrpcInvalidParams  = -32602
+	jsonrpcServerError    = -32000 // 处理函数返回的错误，具体的错误码放在 data.code 中
+)
+
+type jsonrpcRequest struct {
+	Version string          `json:"jsonrpc"`
+	Method  string          `json:"method"`
+	Params  json.RawMessage `json:"params,omitempty"`
+	ID      json.RawMessage `json:"id,omitempty"` // 没有 id 时为 nil，表示通知
+}
+
+type jsonrpcResponse struct {
+	Version string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result,omitempty"`
+	Error   *jsonrpcError   `json:"error,omitempty"`
+	ID      json.RawMessage `json:"id"`
+}
+
+type jsonrpcError struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Data    *jsonrpcErrorData `json:"data,omitempty"`
+}
+
+// jsonrpcErrorData 携带 FancyRPC 的错误码和 Details
+type jsonrpcErrorData struct {
+	Code    string            `json:"code"`
+	Details map[string]string `json:"details,omitempty"`
+}
+
+var jsonNull = json.RawMessage("null")
+
+// ServeJSONRPC 处理一个 JSON-RPC 2.0 的 HTTP POST 请求
+func (server *Server) ServeJSONRPC(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "rpc server: JSON-RPC requires POST", http.StatusMethodNotAllowed)
+		return
+	}
+	data, err := server.readHTTPBody(w, r)
+	if err != nil {
+		if CodeOf(err) == ResourceExhausted {
+			writeJSON(w, http.StatusRequestEntityTooLarge, jsonrpcFailure(jsonNull, jsonrpcInvalidRequest, err.Error(), nil))
+			return
+		}
+		writeJSON(w, http.StatusBadRequest, jsonrpcFailure(jsonNull, jsonrpcParseError, err.Error(), nil))
+		return
+	}
+
+	md := httpMetadata(r)
+	call := func(req *jsonrpcRequest) *jsonrpcResponse {
+		return server.callJSONRPC(r, md, req)
+	}
+
+	data = bytes.TrimSpace(data)
+	if len(data) > 0 && data[0] == '[' {
+		var batch []json.RawMessage
+		if err := json.Unmarshal(data, &batch); err != nil {
+			writeJSON(w, http.StatusOK, jsonrpcFailure(jsonNull, jsonrpcParseError, err.Error(), nil))
+			return
+		}
+		if len(batch) == 0 {
+			writeJSON(w, http.StatusOK, jsonrpcFailure(jsonNull, jsonrpcInvalidRequest, "empty batch", nil))
+			return
+		}
+		// 批量请求中的调用并发执行，回复按请求的顺序排列
+		resps := make([]*jsonrpcResponse, len(batch))
+		var wg sync.WaitGroup
+		for i, raw := range batch {
+			req, resp := parseJSONRPC(raw)
+			if resp != nil {
+				resps[i] = resp
+				continue
+			}
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				resps[i] = call(req)
+			}(i)
+		}
+		wg.Wait()
+		out := make([]*jsonrpcResponse, 0, len(resps))
+		for _, resp := range resps {
+			if resp != nil {
+				out = append(out, resp)
+			}
+		}
+		if len(out) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		writeJSON(w, http.StatusOK, out)
+		return
+	}
+
+	req, resp := parseJSONRPC(data)
+	if resp == nil {
+		resp = call(req)
+	}
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// JSONRPCHandler 返回调用 ServeJSONRPC 的 http.Handler
+func (server *Server) JSONRPCHandler() http.Handler {
+	return http.HandlerFunc(server.ServeJSONRPC)
+}
+
+// parseJSONRPC 请求不合法时返回错误回复
+func parseJSONRPC(raw json.RawMessage) (*jsonrpcRequest, *jsonrpcResponse) {
+	var req jsonrpcRequest
+	if err := json.Unmarshal(raw, &req); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			return nil, jsonrpcFailure(jsonNull, jsonrpcParseError, err.Error(), nil)
+		}
+		return nil, jsonrpcFailure(jsonNull, jsonrpcInvalidRequest, err.Error(), nil)
+	}
+	id := req.ID
+	if id == nil {
+		id = jsonNull
+	}
+	if req.Version != "2.0" || req.Method == "" {
+		return nil, jsonrpcFailure(id, jsonrpcInvalidRequest, `jsonrpc must be "2.0" and method must be set`, nil)
+	}
+	return &req, nil
+}
+
+// callJSONRPC 执行一个调用，通知返回 nil
+func (server *Server) callJSONRPC(r *http.Request, md Metadata, req *jsonrpcRequest) *jsonrpcResponse {
+	result, code, err := server.invokeHTTP(r, md, req.Method, func(argv reflect.Value) error {
+		return decodeJSONRPCParams(req.Params, argv)
+	})
+	if req.ID == nil {
+		if err != nil {
+			server.logger.Log(LevelWarn, "rpc server: JSON-RPC notification failed",
+				F(FieldServiceMethod, req.Method), F(FieldError, err))
+		}
+		return nil
+	}
+	if err != nil {
+		st := statusOf(err)
+		return jsonrpcFailure(req.ID, code, st.Message, &jsonrpcErrorData{Code: st.Code.String(), Details: st.Details})
+	}
+	return &jsonrpcResponse{Version: "2.0", Result: result, ID: req.ID}
+}
+
+// decodeJSONRPCParams 对象直接解码为参数，数组只能有一个元素
+func decodeJSONRPCParams(params json.RawMessage, argv reflect.Value) error {
+	params = bytes.TrimSpace(params)
+	if len(params) == 0 || bytes.Equal(params, jsonNull) {
+		return nil
+	}
+	if params[0] == '[' {
+		var positional []json.RawMessage
+		if err := json.Unmarshal(params, &positional); err != nil {
+			return err
+		}
+		if len(positional) != 1 {
+			return errors.New("positional params must contain exactly one element")
+		}
+		params = positional[0]
+	}
+	return decodeJSONArg(params, argv)
+}
+
+func jsonrpcFailure(id json.RawMessage, code int, msg string, data *jsonrpcErrorData) *jsonrpcResponse {
+	return &jsonrpcResponse{Version: "2.0", Error: &jsonrpcError{Code: code, Message: msg, Data: data}, ID: id}
+}
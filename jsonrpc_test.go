@@ -0,0 +1,113 @@
+package FancyRPC
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func startJSONRPCServer(t *testing.T, opts ...ServerOption) (*Audit, string) {
+	server := NewServer(opts...)
+	var foo Foo
+	audit := &Audit{events: make(chan AuditEvent, 10)}
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	_assert(server.Register(Users{}) == nil, "register Users failed")
+	_assert(server.Register(audit) == nil, "register Audit failed")
+	_assert(server.Register(Rows{}) == nil, "register Rows failed")
+	ts := httptest.NewServer(server.JSONRPCHandler())
+	t.Cleanup(ts.Close)
+	return audit, ts.URL
+}
+
+func postJSONRPC(t *testing.T, url, body string) (int, string) {
+	resp, err := http.Post(url, "application/json", strings.NewReader(body))
+	_assert(err == nil, "post failed: %v", err)
+	defer func() { _ = resp.Body.Close() }()
+	b, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, strings.TrimSpace(string(b))
+}
+
+func TestJSONRPC_Call(t *testing.T) {
+	_, url := startJSONRPCServer(t)
+	cases := []struct{ req, resp string }{
+		{`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`,
+			`{"jsonrpc":"2.0","result":3,"id":1}`},
+		{`{"jsonrpc":"2.0","method":"Foo.Sum","params":[{"Num1":20,"Num2":22}],"id":"a"}`,
+			`{"jsonrpc":"2.0","result":42,"id":"a"}`},
+		{`{"jsonrpc":"2.0","method":"Users.Get","params":[2],"id":null}`,
+			`{"jsonrpc":"2.0","error":{"code":-32000,"message":"user 2 not found","data":{"code":"NotFound","details":{"id":"2"}}},"id":null}`},
+		{`{"jsonrpc":"2.0","method":"Foo.Nope","id":3}`,
+			`{"jsonrpc":"2.0","error":{"code":-32601,"message":"rpc server: can't find method Nope","data":{"code":"NotFound"}},"id":3}`},
+		{`{"jsonrpc":"2.0","method":"Foo.Sum","params":[1,2],"id":4}`,
+			`{"jsonrpc":"2.0","error":{"code":-32602,"message":"rpc server: invalid params: positional params must contain exactly one element","data":{"code":"InvalidArgument"}},"id":4}`},
+		{`{"jsonrpc":"1.0","method":"Foo.Sum","id":5}`,
+			`{"jsonrpc":"2.0","error":{"code":-32600,"message":"jsonrpc must be \"2.0\" and method must be set"},"id":5}`},
+		{`{"jsonrpc":"2.0","method"`,
+			`{"jsonrpc":"2.0","error":{"code":-32700,"message":"unexpected end of JSON input"},"id":null}`},
+	}
+	for _, c := range cases {
+		status, resp := postJSONRPC(t, url, c.req)
+		_assert(status == http.StatusOK && resp == c.resp, "request %s\n got %d %s\nwant %s", c.req, status, resp, c.resp)
+	}
+
+	_, body := postJSONRPC(t, url, `{"jsonrpc":"2.0","method":"Rows.List","params":1,"id":6}`)
+	_assert(strings.Contains(body, `"code":"Unimplemented"`), "expect streaming method rejected, got %s", body)
+	resp, err := http.Get(url)
+	_assert(err == nil && resp.StatusCode == http.StatusMethodNotAllowed, "expect 405 for GET, got %v %v", resp, err)
+	_ = resp.Body.Close()
+}
+
+func TestJSONRPC_BatchAndNotifications(t *testing.T) {
+	audit, url := startJSONRPCServer(t)
+	status, body := postJSONRPC(t, url, `[
+		{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":1},"id":1},
+		{"jsonrpc":"2.0","method":"Audit.Record","params":{"User":"alice","Action":"login"}},
+		{"foo":"bar"},
+		{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":2,"Num2":2},"id":2}
+	]`)
+	_assert(status == http.StatusOK, "unexpected status %d", status)
+	var resps []struct {
+		Result json.RawMessage
+		Error  *struct{ Code int }
+		ID     json.RawMessage
+	}
+	_assert(json.Unmarshal([]byte(body), &resps) == nil && len(resps) == 3, "unexpected batch response %s", body)
+	_assert(string(resps[0].Result) == "2" && string(resps[0].ID) == "1", "unexpected first response %s", body)
+	_assert(resps[1].Error != nil && resps[1].Error.Code == -32600, "expect invalid request, got %s", body)
+	_assert(string(resps[2].Result) == "4" && string(resps[2].ID) == "2", "unexpected last response %s", body)
+
+	select {
+	case ev := <-audit.events:
+		_assert(ev.User == "alice", "unexpected event %+v", ev)
+	case <-time.After(time.Second):
+		t.Fatal("notification not executed")
+	}
+
+	status, body = postJSONRPC(t, url, `[{"jsonrpc":"2.0","method":"Audit.Record","params":{"User":"bob","Action":"logout"}}]`)
+	_assert(status == http.StatusNoContent && body == "", "expect 204 for notifications only, got %d %s", status, body)
+	status, body = postJSONRPC(t, url, `[]`)
+	_assert(status == http.StatusOK && strings.Contains(body, "-32600"), "expect invalid request for empty batch, got %s", body)
+}
+
+func TestJSONRPC_Authenticator(t *testing.T) {
+	_, url := startJSONRPCServer(t, WithAuthenticator(func(info *RequestInfo) (string, error) {
+		if info.Metadata.Get("authorization") != "Bearer secret" {
+			return "", NewError(Unauthenticated, "bad token")
+		}
+		return "alice", nil
+	}))
+	_, body := postJSONRPC(t, url, `{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`)
+	_assert(strings.Contains(body, `"code":"Unauthenticated"`), "expect unauthenticated, got %s", body)
+
+	req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(`{"jsonrpc":"2.0","method":"Foo.Sum","params":{"Num1":1,"Num2":2},"id":1}`))
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "post failed: %v", err)
+	var out struct{ Result int }
+	_assert(json.NewDecoder(resp.Body).Decode(&out) == nil && out.Result == 3, "unexpected result %+v", out)
+	_ = resp.Body.Close()
+}
@@ -0,0 +1,156 @@
+package FancyRPC
+
+import (
+	"FancyRPC/codec"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 心跳：两端各自按配置的间隔发送 FramePing，对端收到后立即回复 FramePong。
+// 发出 ping 之后超时仍未收到 pong 就认为对端已经失联，直接关闭连接，
+// 读循环随之退出，客户端上等待中的调用以 ErrHeartbeatTimeout 失败。
+// 心跳帧只属于连接，不占用 Seq，也不计入服务端的空闲时间。
+
+// ErrHeartbeatTimeout 对端在超时时间内没有回复 pong，连接已被关闭
+var ErrHeartbeatTimeout error = NewError(Unavailable, "rpc: heartbeat timeout")
+
+type heartbeat struct {
+	interval time.Duration
+	timeout  time.Duration
+	cc       codec.Codec
+	sending  *sync.Mutex
+	lg       Logger
+
+	pong     chan struct{}
+	stop     chan struct{}
+	stopOnce sync.Once
+	timedOut atomic.Bool
+}
+
+// startHeartbeat interval 不大于 0 时不开启心跳，返回 nil；timeout 不大于 0 时等于 interval
+func startHeartbeat(cc codec.Codec, sending *sync.Mutex, lg Logger, interval, timeout time.Duration) *heartbeat {
+	if interval <= 0 {
+		return nil
+	}
+	if timeout <= 0 {
+		timeout = interval
+	}
+	hb := &heartbeat{
+		interval: interval,
+		timeout:  timeout,
+		cc:       cc,
+		sending:  sending,
+		lg:       lg,
+		pong:     make(chan struct{}, 1),
+		stop:     make(chan struct{}),
+	}
+	go hb.run()
+	return hb
+}
+
+func (hb *heartbeat) run() {
+	ticker := time.NewTicker(hb.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-hb.stop:
+			return
+		}
+		// 丢掉上一轮迟到的 pong
+		select {
+		case <-hb.pong:
+		default:
+		}
+		// 超时从写 ping 之前开始计算：对端不读数据时写操作本身会阻塞，超时后关闭连接让它返回
+		timer := time.AfterFunc(hb.timeout, func() {
+			hb.timedOut.Store(true)
+			hb.lg.Log(LevelWarn, "rpc: heartbeat timeout, closing connection", F("timeout", hb.timeout))
+			_ = hb.cc.Close()
+		})
+		if err := writeControl(hb.cc, hb.sending, codec.FramePing); err != nil {
+			// 写失败说明连接已经坏了，读循环会处理
+			timer.Stop()
+			return
+		}
+		select {
+		case <-hb.pong:
+			if !timer.Stop() {
+				// pong 到达时连接已经因为超时被关闭
+				return
+			}
+		case <-hb.stop:
+			timer.Stop()
+			return
+		}
+	}
+}
+
+// close 在读循环退出时调用，nil 也可以安全调用
+func (hb *heartbeat) close() {
+	if hb != nil {
+		hb.stopOnce.Do(func() { close(hb.stop) })
+	}
+}
+
+// err 连接因为心跳超时被关闭时返回 ErrHeartbeatTimeout，否则原样返回 err
+func (hb *heartbeat) err(err error) error {
+	if hb != nil && hb.timedOut.Load() {
+		return ErrHeartbeatTimeout
+	}
+	return err
+}
+
+// handleKeepalive 在读循环中处理心跳帧，h 不是心跳帧时返回 false
+func handleKeepalive(cc codec.Codec, sending *sync.Mutex, hb *heartbeat, h *codec.Header) (bool, error) {
+	switch h.Type {
+	case codec.FramePing:
+		if err := cc.ReadBody(nil); err != nil {
+			return true, err
+		}
+		return true, writeControl(cc, sending, codec.FramePong)
+	case codec.FramePong:
+		if hb != nil {
+			select {
+			case hb.pong <- struct{}{}:
+			default:
+			}
+		}
+		return true, cc.ReadBody(nil)
+	}
+	return false, nil
+}
+
+func writeControl(cc codec.Codec, sending *sync.Mutex, typ codec.FrameType) error {
+	sending.Lock()
+	defer sending.Unlock()
+	return cc.Write(&codec.Header{Type: typ}, invalidRequest)
+}
+
+// reapIdle 连接上没有进行中的请求、且超过 d 没有收到心跳以外的帧时关闭连接
+func (sc *serverConn) reapIdle(d time.Duration) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	for {
+		select {
+		case <-timer.C:
+		case <-sc.ctx.Done():
+			return
+		}
+		idle := time.Since(time.Unix(0, sc.lastActive.Load()))
+		if sc.inFlight.Load() == 0 && idle >= d {
+			sc.lg.Log(LevelInfo, "rpc server: closing idle connection", F("idle", idle))
+			_ = sc.cc.Close()
+			return
+		}
+		if idle >= d {
+			idle = 0
+		}
+		timer.Reset(d - idle)
+	}
+}
+
+func (sc *serverConn) touch() {
+	sc.lastActive.Store(time.Now().UnixNano())
+}
@@ -0,0 +1,99 @@
+package FancyRPC
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestHeartbeat_ClientDetectsDeadServer(t *testing.T) {
+	// 只读不写的“服务端”，既不回复请求也不回复心跳
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go func() {
+		conn, err := l.Accept()
+		if err == nil {
+			_, _ = io.Copy(io.Discard, conn)
+		}
+	}()
+	client, err := Dial("tcp", l.Addr().String(), &Option{HeartbeatInterval: 20 * time.Millisecond, HeartbeatTimeout: 50 * time.Millisecond})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	err = client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(errors.Is(err, ErrHeartbeatTimeout), "expect heartbeat timeout, got %v", err)
+	_assert(!client.IsAvailable(), "client should be unavailable after heartbeat timeout")
+}
+
+func TestHeartbeat_HealthyConnection(t *testing.T) {
+	_, addr := startTestServer(t, WithHeartbeat(10*time.Millisecond, 200*time.Millisecond))
+	client, err := Dial("tcp", addr, &Option{HeartbeatInterval: 10 * time.Millisecond, HeartbeatTimeout: 200 * time.Millisecond})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	time.Sleep(100 * time.Millisecond)
+	var reply int
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "call failed")
+}
+
+func TestHeartbeat_ServerClosesDeadClient(t *testing.T) {
+	_, addr := startTestServer(t, WithHeartbeat(20*time.Millisecond, 50*time.Millisecond))
+	conn, err := net.Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = conn.Close() }()
+	_ = json.NewEncoder(conn).Encode(DefaultOption)
+
+	// 从不回复 pong，服务端应当在超时后关闭连接，读到 EOF
+	_ = conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, err = io.Copy(io.Discard, conn)
+	_assert(err == nil, "server did not close the connection: %v", err)
+}
+
+// closeNotifyConn 记录连接是否被关闭
+type closeNotifyConn struct {
+	net.Conn
+	once   sync.Once
+	closed chan struct{}
+}
+
+func (c *closeNotifyConn) Close() error {
+	c.once.Do(func() { close(c.closed) })
+	return c.Conn.Close()
+}
+
+func TestHeartbeat_ServerClosesClientNotReading(t *testing.T) {
+	server := NewServer(WithHeartbeat(20*time.Millisecond, 50*time.Millisecond))
+	// net.Pipe 没有缓冲，客户端不读时服务端写 ping 会一直阻塞
+	serverSide, clientSide := net.Pipe()
+	defer func() { _ = clientSide.Close() }()
+	conn := &closeNotifyConn{Conn: serverSide, closed: make(chan struct{})}
+	go server.ServerConn(conn)
+	_assert(json.NewEncoder(clientSide).Encode(DefaultOption) == nil, "write option failed")
+
+	select {
+	case <-conn.closed:
+	case <-time.After(time.Second):
+		t.Fatal("server did not close a connection blocked on writing ping")
+	}
+}
+
+func TestServer_IdleTimeout(t *testing.T) {
+	_, addr := startTestServer(t, WithIdleTimeout(50*time.Millisecond))
+	// 客户端的心跳不算请求，不会让连接保持活跃
+	client, err := Dial("tcp", addr, &Option{HeartbeatInterval: 10 * time.Millisecond})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil, "call failed")
+	deadline := time.Now().Add(time.Second)
+	for client.IsAvailable() && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	_assert(!client.IsAvailable(), "idle connection should be closed by the server")
+}
@@ -0,0 +1,167 @@
+package FancyRPC
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+// 并发限制：全局和单个方法各有一个上限，请求必须同时拿到两者的名额才会执行。
+// 拿不到名额时，排队未满则进入队列等待（可设超时），否则立即以 ErrServerBusy 拒绝。
+// 名额在读循环中尝试获取，只有拿到名额或进入队列的请求才会启动处理协程，
+// 因此一个 Server 上的处理协程数不超过全局上限加队列长度。
+
+// ErrServerBusy 请求超过并发限制被拒绝，或者排队超时
+var ErrServerBusy error = NewError(ResourceExhausted, "rpc server: server busy")
+
+// WithMaxConcurrency 限制 Server 上同时执行的请求数，n 不大于 0 时不限制
+func WithMaxConcurrency(n int) ServerOption {
+	return func(server *Server) {
+		server.maxConcurrency = n
+	}
+}
+
+// WithMethodConcurrency 限制某个方法同时执行的请求数，serviceMethod 形如 "Foo.Sum"
+func WithMethodConcurrency(serviceMethod string, n int) ServerOption {
+	return func(server *Server) {
+		if server.methodConcurrency == nil {
+			server.methodConcurrency = make(map[string]int)
+		}
+		server.methodConcurrency[serviceMethod] = n
+	}
+}
+
+// WithQueue 超过并发限制时最多 size 个请求排队等待，每个最多等待 timeout，timeout 不大于 0 时一直等到连接关闭。
+// 不设置时不排队，超过限制的请求立即被拒绝。
+func WithQueue(size int, timeout time.Duration) ServerOption {
+	return func(server *Server) {
+		server.queueSize = size
+		server.queueTimeout = timeout
+	}
+}
+
+type limitConfig struct {
+	maxConcurrency    int
+	methodConcurrency map[string]int
+	queueSize         int
+	queueTimeout      time.Duration
+}
+
+// semaphore 容量即并发上限
+type semaphore chan struct{}
+
+func (s semaphore) tryAcquire() bool {
+	select {
+	case s <- struct{}{}:
+		return true
+	default:
+		return false
+	}
+}
+
+func (s semaphore) release() { <-s }
+
+type concurrencyLimits struct {
+	global  semaphore // 不限制时为 nil
+	methods map[string]semaphore
+	cfg     limitConfig
+	queued  atomic.Int64
+	metrics *serverMetrics
+}
+
+func newConcurrencyLimits(cfg limitConfig, sm *serverMetrics) *concurrencyLimits {
+	cl := &concurrencyLimits{methods: make(map[string]semaphore), cfg: cfg, metrics: sm}
+	if cfg.maxConcurrency > 0 {
+		cl.global = make(semaphore, cfg.maxConcurrency)
+	}
+	for method, n := range cfg.methodConcurrency {
+		if n > 0 {
+			cl.methods[method] = make(semaphore, n)
+		}
+	}
+	if cl.global == nil && len(cl.methods) == 0 {
+		return nil
+	}
+	return cl
+}
+
+// acquireFunc 在处理协程中调用，返回的 release 在处理函数返回后调用
+type acquireFunc func(ctx context.Context) (release func(), err error)
+
+func noRelease() {}
+
+func acquired(release func()) acquireFunc {
+	return func(context.Context) (func(), error) { return release, nil }
+}
+
+// admit 在读循环中调用，不会阻塞：能拿到名额时直接返回，需要排队时返回在队列中等待的 acquireFunc
+func (cl *concurrencyLimits) admit(method string) (acquireFunc, error) {
+	if cl == nil {
+		return acquired(noRelease), nil
+	}
+	// 先方法后全局：排队的请求等待方法名额时不占用全局名额，热点方法排满队也不会饿死其他方法
+	sems := make([]semaphore, 0, 2)
+	if s := cl.methods[method]; s != nil {
+		sems = append(sems, s)
+	}
+	if cl.global != nil {
+		sems = append(sems, cl.global)
+	}
+	if len(sems) == 0 {
+		return acquired(noRelease), nil
+	}
+	release := func() {
+		for _, s := range sems {
+			s.release()
+		}
+	}
+	if tryAcquireAll(sems) {
+		return acquired(release), nil
+	}
+	if cl.queued.Add(1) > int64(cl.cfg.queueSize) {
+		cl.queued.Add(-1)
+		return nil, ErrServerBusy
+	}
+	cl.setDepth(method, 1)
+	return func(ctx context.Context) (func(), error) {
+		defer func() {
+			cl.queued.Add(-1)
+			cl.setDepth(method, -1)
+		}()
+		if cl.cfg.queueTimeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, cl.cfg.queueTimeout)
+			defer cancel()
+		}
+		for i, s := range sems {
+			select {
+			case s <- struct{}{}:
+			case <-ctx.Done():
+				for _, held := range sems[:i] {
+					held.release()
+				}
+				return nil, ErrServerBusy
+			}
+		}
+		return release, nil
+	}, nil
+}
+
+// tryAcquireAll 按顺序获取全部名额，失败时归还已经拿到的
+func tryAcquireAll(sems []semaphore) bool {
+	for i, s := range sems {
+		if !s.tryAcquire() {
+			for _, held := range sems[:i] {
+				held.release()
+			}
+			return false
+		}
+	}
+	return true
+}
+
+func (cl *concurrencyLimits) setDepth(method string, delta float64) {
+	if cl.metrics != nil {
+		cl.metrics.queueDepth.with(method).Add(delta)
+	}
+}
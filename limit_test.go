@@ -0,0 +1,133 @@
+package FancyRPC
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// Barrier 的 Wait 在 release 关闭之前一直阻塞，开始执行时通知 started
+type Barrier struct {
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *Barrier) Wait(n int, reply *int) error {
+	b.started <- struct{}{}
+	<-b.release
+	*reply = n
+	return nil
+}
+
+func startLimitServer(t *testing.T, opts ...ServerOption) (*Barrier, *Client) {
+	t.Helper()
+	server, addr := startTestServer(t, opts...)
+	b := &Barrier{started: make(chan struct{}, 10), release: make(chan struct{})}
+	_assert(server.Register(b) == nil, "register Barrier failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	t.Cleanup(func() { _ = client.Close() })
+	return b, client
+}
+
+func TestConcurrencyLimit_Reject(t *testing.T) {
+	b, client := startLimitServer(t, WithMaxConcurrency(1))
+	first := client.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+
+	var reply int
+	err := client.Call("Barrier.Wait", 2, &reply)
+	_assert(err != nil && err.Error() == ErrServerBusy.Error(), "expect server busy, got %v", err)
+	err = client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err != nil && err.Error() == ErrServerBusy.Error(), "global limit should apply to every method, got %v", err)
+
+	close(b.release)
+	_assert((<-first.Done).Error == nil, "first call failed")
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil, "call after release failed")
+}
+
+func TestConcurrencyLimit_Queue(t *testing.T) {
+	m := NewMetrics()
+	b, client := startLimitServer(t, WithMetrics(m), WithMaxConcurrency(1), WithQueue(1, time.Second))
+	first := client.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+	second := client.Go("Barrier.Wait", 2, new(int), nil)
+
+	var out strings.Builder
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		out.Reset()
+		_, _ = m.WriteTo(&out)
+		if strings.Contains(out.String(), `fancyrpc_server_queue_depth{method="Barrier.Wait"} 1`) {
+			break
+		}
+	}
+	_assert(strings.Contains(out.String(), `fancyrpc_server_queue_depth{method="Barrier.Wait"} 1`), "expect queue depth 1 in\n%s", out.String())
+
+	// 队列已满，第三个请求立即被拒绝
+	var reply int
+	err := client.Call("Barrier.Wait", 3, &reply)
+	_assert(err != nil && err.Error() == ErrServerBusy.Error(), "expect server busy, got %v", err)
+
+	close(b.release)
+	_assert((<-first.Done).Error == nil && (<-second.Done).Error == nil, "queued call failed")
+	out.Reset()
+	_, _ = m.WriteTo(&out)
+	for _, want := range []string{
+		`fancyrpc_server_queue_depth{method="Barrier.Wait"} 0`,
+		`fancyrpc_server_rejected_requests_total{method="Barrier.Wait"} 1`,
+		`fancyrpc_server_requests_total{method="Barrier.Wait"} 2`,
+	} {
+		_assert(strings.Contains(out.String(), want), "missing %q in\n%s", want, out.String())
+	}
+}
+
+func TestConcurrencyLimit_QueueTimeout(t *testing.T) {
+	b, client := startLimitServer(t, WithMaxConcurrency(1), WithQueue(1, 20*time.Millisecond))
+	defer close(b.release)
+	client.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+	var reply int
+	err := client.Call("Barrier.Wait", 2, &reply)
+	_assert(err != nil && err.Error() == ErrServerBusy.Error(), "expect server busy after queue timeout, got %v", err)
+}
+
+func TestConcurrencyLimit_PerMethod(t *testing.T) {
+	b, client := startLimitServer(t, WithMethodConcurrency("Barrier.Wait", 1))
+	defer close(b.release)
+	client.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+
+	var reply int
+	err := client.Call("Barrier.Wait", 2, &reply)
+	_assert(err != nil && err.Error() == ErrServerBusy.Error(), "expect server busy, got %v", err)
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3,
+		"other methods should not be limited")
+}
+
+// 排队等待热点方法的请求不占用全局名额，其他方法仍然可以执行
+func TestConcurrencyLimit_HotMethodDoesNotStarveOthers(t *testing.T) {
+	m := NewMetrics()
+	b, client := startLimitServer(t, WithMetrics(m), WithMaxConcurrency(2),
+		WithMethodConcurrency("Barrier.Wait", 1), WithQueue(5, time.Second))
+	defer close(b.release)
+	client.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+	client.Go("Barrier.Wait", 2, new(int), nil)
+	client.Go("Barrier.Wait", 3, new(int), nil)
+
+	var out strings.Builder
+	for deadline := time.Now().Add(time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		out.Reset()
+		_, _ = m.WriteTo(&out)
+		if strings.Contains(out.String(), `fancyrpc_server_queue_depth{method="Barrier.Wait"} 2`) {
+			break
+		}
+	}
+	_assert(strings.Contains(out.String(), `fancyrpc_server_queue_depth{method="Barrier.Wait"} 2`), "expect queue depth 2 in\n%s", out.String())
+
+	var reply int
+	start := time.Now()
+	err := client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "idle method should still be admitted, got %v", err)
+	_assert(time.Since(start) < 500*time.Millisecond, "idle method should not wait in the queue")
+}
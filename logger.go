@@ -0,0 +1,156 @@
+package FancyRPC
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+)
+
+// Level 日志级别，数值与 log/slog 保持一致，方便适配
+type Level int
+
+const (
+	LevelDebug Level = -4
+	LevelInfo  Level = 0
+	LevelWarn  Level = 4
+	LevelError Level = 8
+)
+
+func (l Level) String() string {
+	switch {
+	case l < LevelInfo:
+		return "DEBUG"
+	case l < LevelWarn:
+		return "INFO"
+	case l < LevelError:
+		return "WARN"
+	default:
+		return "ERROR"
+	}
+}
+
+// Field 结构化日志字段，例如 seq、service_method、remote_addr
+type Field struct {
+	Key   string
+	Value interface{}
+}
+
+// F 构造一个 Field
+func F(key string, value interface{}) Field {
+	return Field{Key: key, Value: value}
+}
+
+// 常用字段名
+const (
+	FieldSeq           = "seq"
+	FieldServiceMethod = "service_method"
+	FieldRemoteAddr    = "remote_addr"
+	FieldError         = "error"
+)
+
+// Logger 可插拔的日志接口，Server 和 Client 各自持有一个。
+// Enabled 用于在热路径上提前判断，避免无意义的字段构造。
+// With 返回携带固定字段的子 Logger，例如每个连接带上 remote_addr。
+type Logger interface {
+	Enabled(level Level) bool
+	Log(level Level, msg string, fields ...Field)
+	With(fields ...Field) Logger
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Enabled(Level) bool          { return false }
+func (nopLogger) Log(Level, string, ...Field) {}
+func (l nopLogger) With(...Field) Logger      { return l }
+
+// NopLogger 静默 Logger，Server 和 Client 默认使用它
+var NopLogger Logger = nopLogger{}
+
+// slogLogger 把 Logger 适配到 log/slog
+type slogLogger struct {
+	l *slog.Logger
+}
+
+// NewSlogLogger 使用 *slog.Logger 作为输出，l 为 nil 时使用 slog.Default()
+func NewSlogLogger(l *slog.Logger) Logger {
+	if l == nil {
+		l = slog.Default()
+	}
+	return &slogLogger{l: l}
+}
+
+func (s *slogLogger) Enabled(level Level) bool {
+	return s.l.Enabled(context.Background(), slog.Level(level))
+}
+
+func (s *slogLogger) Log(level Level, msg string, fields ...Field) {
+	s.l.LogAttrs(context.Background(), slog.Level(level), msg, toAttrs(fields)...)
+}
+
+func (s *slogLogger) With(fields ...Field) Logger {
+	if len(fields) == 0 {
+		return s
+	}
+	attrs := toAttrs(fields)
+	args := make([]interface{}, len(attrs))
+	for i, a := range attrs {
+		args[i] = a
+	}
+	return &slogLogger{l: s.l.With(args...)}
+}
+
+func toAttrs(fields []Field) []slog.Attr {
+	attrs := make([]slog.Attr, len(fields))
+	for i, f := range fields {
+		attrs[i] = slog.Any(f.Key, f.Value)
+	}
+	return attrs
+}
+
+// stdLogger 把 Logger 适配到标准库 log，字段以 key=value 形式追加在消息后
+type stdLogger struct {
+	l      *log.Logger
+	min    Level
+	fields []Field
+}
+
+// NewStdLogger 使用 *log.Logger 输出级别不低于 min 的日志，l 为 nil 时使用 log.Default()
+func NewStdLogger(l *log.Logger, min Level) Logger {
+	if l == nil {
+		l = log.Default()
+	}
+	return &stdLogger{l: l, min: min}
+}
+
+func (s *stdLogger) Enabled(level Level) bool {
+	return level >= s.min
+}
+
+func (s *stdLogger) Log(level Level, msg string, fields ...Field) {
+	if !s.Enabled(level) {
+		return
+	}
+	var b strings.Builder
+	b.WriteString(level.String())
+	b.WriteByte(' ')
+	b.WriteString(msg)
+	for _, f := range s.fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	for _, f := range fields {
+		fmt.Fprintf(&b, " %s=%v", f.Key, f.Value)
+	}
+	_ = s.l.Output(2, b.String())
+}
+
+func (s *stdLogger) With(fields ...Field) Logger {
+	if len(fields) == 0 {
+		return s
+	}
+	merged := make([]Field, 0, len(s.fields)+len(fields))
+	merged = append(merged, s.fields...)
+	merged = append(merged, fields...)
+	return &stdLogger{l: s.l, min: s.min, fields: merged}
+}
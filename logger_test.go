@@ -0,0 +1,108 @@
+package FancyRPC
+
+import (
+	"bytes"
+	"log"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// recordLogger 记录所有日志，便于断言
+type recordLogger struct {
+	mu      *sync.Mutex
+	entries *[]logEntry
+	fields  []Field
+}
+
+type logEntry struct {
+	level  Level
+	msg    string
+	fields map[string]interface{}
+}
+
+func newRecordLogger() *recordLogger {
+	return &recordLogger{mu: new(sync.Mutex), entries: new([]logEntry)}
+}
+
+func (r *recordLogger) Enabled(Level) bool { return true }
+
+func (r *recordLogger) Log(level Level, msg string, fields ...Field) {
+	m := make(map[string]interface{})
+	for _, f := range append(append([]Field{}, r.fields...), fields...) {
+		m[f.Key] = f.Value
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	*r.entries = append(*r.entries, logEntry{level: level, msg: msg, fields: m})
+}
+
+func (r *recordLogger) With(fields ...Field) Logger {
+	return &recordLogger{mu: r.mu, entries: r.entries, fields: append(append([]Field{}, r.fields...), fields...)}
+}
+
+func (r *recordLogger) find(msg string) (logEntry, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, e := range *r.entries {
+		if e.msg == msg {
+			return e, true
+		}
+	}
+	return logEntry{}, false
+}
+
+// startTestServer 在随机端口上启动一个注册了 Foo 的 Server，返回监听地址
+func startTestServer(t *testing.T, opts ...ServerOption) (*Server, string) {
+	t.Helper()
+	server := NewServer(opts...)
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return server, l.Addr().String()
+}
+
+func TestLogger_StructuredFields(t *testing.T) {
+	lg := newRecordLogger()
+	_, addr := startTestServer(t, WithLogger(lg))
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "failed to call Foo.Sum")
+	err = client.Call("Foo.Missing", &Args{}, &reply)
+	_assert(err != nil, "expect error for Foo.Missing")
+
+	e, ok := lg.find("rpc server: find service error")
+	_assert(ok, "expect find service error to be logged")
+	_assert(e.level == LevelWarn, "expect warn level, got %s", e.level)
+	_assert(e.fields[FieldServiceMethod] == "Foo.Missing", "wrong service_method field %v", e.fields[FieldServiceMethod])
+	_assert(e.fields[FieldSeq] == uint64(2), "wrong seq field %v", e.fields[FieldSeq])
+	_assert(e.fields[FieldRemoteAddr] != nil, "expect remote_addr field")
+}
+
+func TestLogger_Adapters(t *testing.T) {
+	var buf bytes.Buffer
+	std := NewStdLogger(log.New(&buf, "", 0), LevelInfo).With(F(FieldRemoteAddr, "1.2.3.4:5"))
+	std.Log(LevelDebug, "hidden")
+	std.Log(LevelWarn, "shown", F(FieldSeq, 7))
+	_assert(buf.String() == "WARN shown remote_addr=1.2.3.4:5 seq=7\n", "unexpected std output %q", buf.String())
+
+	buf.Reset()
+	sl := NewSlogLogger(slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	_assert(sl.Enabled(LevelDebug), "slog logger should enable debug")
+	sl.With(F(FieldServiceMethod, "Foo.Sum")).Log(LevelError, "boom", F(FieldSeq, 1))
+	out := buf.String()
+	_assert(strings.Contains(out, "level=ERROR") && strings.Contains(out, "service_method=Foo.Sum") &&
+		strings.Contains(out, "seq=1"), "unexpected slog output %q", out)
+
+	_assert(!NopLogger.Enabled(LevelError), "nop logger should be silent")
+}
@@ -0,0 +1,31 @@
+// Code generated by fancyrpc-gen. DO NOT EDIT.
+
+package main
+
+import (
+	"context"
+
+	"FancyRPC"
+)
+
+// FooClient 是 Foo 服务的类型化客户端
+type FooClient struct {
+	cc FancyRPC.ClientConn
+}
+
+// NewFooClient cc 可以是 *FancyRPC.Client 或者 *FancyRPC.Pool
+func NewFooClient(cc FancyRPC.ClientConn) *FooClient {
+	return &FooClient{cc: cc}
+}
+
+// Sum 调用 Foo.Sum
+func (c *FooClient) Sum(ctx context.Context, args Args) (int, error) {
+	var reply int
+	err := c.cc.CallContext(ctx, "Foo.Sum", args, &reply)
+	return reply, err
+}
+
+// RegisterFoo 在 server 上注册 rcvr，服务名为 "Foo"
+func RegisterFoo(server *FancyRPC.Server, rcvr *Foo) error {
+	return server.Register(rcvr)
+}
@@ -2,6 +2,7 @@ package main
 
 import (
 	"FancyRPC"
+	"context"
 	"log"
 	"net"
 	"sync"
@@ -21,7 +22,7 @@ import (
 
 func startServer(addr chan string) {
 	var foo Foo
-	if err := FancyRPC.Register(&foo); err != nil {
+	if err := RegisterFoo(FancyRPC.DefaultServer, &foo); err != nil {
 		log.Fatal("register error", err)
 
 	}
@@ -36,6 +37,8 @@ func startServer(addr chan string) {
 	log.Println("server accept ok 1")
 }
 
+//go:generate go run FancyRPC/cmd/fancyrpc-gen -type Foo
+
 // 被调用的目标函数
 // 可以使用 type 关键字来创建自定义类型。
 // 在这里，Foo 是一个新的类型，它是基于 int 类型的。
@@ -81,6 +84,7 @@ func main() {
 	//使用管道确保服务端端口监听成功，客户端再发起请求
 	client, _ := FancyRPC.Dial("tcp", <-addr)
 	defer func() { _ = client.Close() }()
+	foo := NewFooClient(client) // 生成的客户端，方法名和参数类型在编译期检查
 
 	//time.Sleep(time.Second)
 	var wg sync.WaitGroup
@@ -117,9 +121,9 @@ func main() {
 		go func(i int) {
 			defer wg.Done()
 			//args := fmt.Sprintf("rpc req %d", i)
-			args := &Args{Num1: i, Num2: i + i}
-			var reply int
-			if err := client.Call("Foo.Sum.", args, &reply); err != nil {
+			args := Args{Num1: i, Num2: i + i}
+			reply, err := foo.Sum(context.Background(), args)
+			if err != nil {
 				log.Printf("call Foo.Sum err %s  %d \n", err, 1)
 			} else {
 				log.Printf("%d + %d = %d", args.Num1, args.Num2, reply)
@@ -0,0 +1,95 @@
+package FancyRPC
+
+import (
+	"FancyRPC/codec"
+	"context"
+)
+
+// Metadata 随请求发送的键值对，放在 codec.Header.Metadata 中，例如租户、令牌等
+type Metadata map[string]string
+
+// Get 对 nil 也可以安全调用
+func (md Metadata) Get(key string) string {
+	return md[key]
+}
+
+type outgoingMetadataKey struct{}
+
+type incomingMetadataKey struct{}
+
+type principalContextKey struct{}
+
+// ContextWithMetadata 返回附带 md 的 context，用它发起的调用会把 md 发送给对端，与已有的同名键合并时以 md 为准。
+// 服务端处理函数收到的 metadata 不会自动随反向调用转发。
+func ContextWithMetadata(ctx context.Context, md Metadata) context.Context {
+	merged := make(Metadata, len(md))
+	for k, v := range outgoingMetadata(ctx) {
+		merged[k] = v
+	}
+	for k, v := range md {
+		merged[k] = v
+	}
+	return context.WithValue(ctx, outgoingMetadataKey{}, merged)
+}
+
+func outgoingMetadata(ctx context.Context) Metadata {
+	if ctx == nil {
+		return nil
+	}
+	md, _ := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md
+}
+
+// MetadataFromContext 返回调用方随请求发送的 metadata，ctx 必须是处理函数收到的 context
+func MetadataFromContext(ctx context.Context) Metadata {
+	md, _ := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md
+}
+
+// PrincipalFromContext 返回 Authenticator 认证出的调用方身份，未配置 Authenticator 时为空
+func PrincipalFromContext(ctx context.Context) string {
+	p, _ := ctx.Value(principalContextKey{}).(string)
+	return p
+}
+
+// RequestInfo 在读取 body 之前就能得到的请求信息，交给 Authenticator 和 RateLimiter 使用
+type RequestInfo struct {
+	ServiceMethod string
+	RemoteAddr    string // 连接不是 net.Conn 时为空
+	Metadata      Metadata
+	Principal     string // Authenticator 认证出的身份
+}
+
+// Authenticator 根据请求信息认证调用方，返回的身份可以通过 PrincipalFromContext 取得，返回错误时请求被拒绝
+type Authenticator func(info *RequestInfo) (principal string, err error)
+
+// WithAuthenticator 每个请求在读取 body 之前先经过 a 认证
+func WithAuthenticator(a Authenticator) ServerOption {
+	return func(server *Server) {
+		server.authenticator = a
+	}
+}
+
+// admitRequest 在读取 body 之前认证并限流，返回处理函数使用的 context
+func (server *Server) admitRequest(sc *serverConn, h *codec.Header) (context.Context, error) {
+	return server.admit(sc.ctx, &RequestInfo{ServiceMethod: h.ServiceMethod, RemoteAddr: sc.remoteAddr, Metadata: h.Metadata})
+}
+
+// admit 对 info 描述的请求做认证和限流，ctx 是连接或 HTTP 请求的 context
+func (server *Server) admit(ctx context.Context, info *RequestInfo) (context.Context, error) {
+	if len(info.Metadata) > 0 {
+		ctx = context.WithValue(ctx, incomingMetadataKey{}, info.Metadata)
+	}
+	if server.authenticator != nil {
+		principal, err := server.authenticator(info)
+		if err != nil {
+			return nil, err
+		}
+		info.Principal = principal
+		ctx = context.WithValue(ctx, principalContextKey{}, principal)
+	}
+	if server.rateLimiter != nil && !server.rateLimiter.Allow(info) {
+		return nil, ErrQuotaExceeded
+	}
+	return ctx, nil
+}
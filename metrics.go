@@ -0,0 +1,349 @@
+package FancyRPC
+
+import (
+	"bufio"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics 是一个极简的指标注册表，按 Prometheus 文本格式输出，
+// 不依赖 client_golang。同一个 Metrics 可以同时交给 Server 和 Client 使用。
+type Metrics struct {
+	mu       sync.RWMutex
+	families map[string]*metricFamily
+}
+
+func NewMetrics() *Metrics {
+	return &Metrics{families: make(map[string]*metricFamily)}
+}
+
+type metricKind string
+
+const (
+	kindCounter   metricKind = "counter"
+	kindGauge     metricKind = "gauge"
+	kindHistogram metricKind = "histogram"
+)
+
+// DefaultLatencyBuckets 延迟直方图的默认桶，单位秒
+var DefaultLatencyBuckets = []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+type metricFamily struct {
+	name    string
+	help    string
+	kind    metricKind
+	labels  []string
+	buckets []float64
+
+	mu     sync.RWMutex
+	series map[string]*metricSeries
+}
+
+// metricSeries 一组 label 取值对应的时间序列，数值都用原子操作维护
+type metricSeries struct {
+	labelValues []string
+	bits        uint64   // counter/gauge 的值，math.Float64bits 编码
+	counts      []uint64 // histogram 每个桶的计数（非累计）
+	count       uint64
+	sumBits     uint64
+}
+
+func (m *Metrics) family(name, help string, kind metricKind, buckets []float64, labels ...string) *metricFamily {
+	m.mu.RLock()
+	f := m.families[name]
+	m.mu.RUnlock()
+	if f != nil {
+		return f
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if f = m.families[name]; f == nil {
+		f = &metricFamily{name: name, help: help, kind: kind, labels: labels, buckets: buckets, series: make(map[string]*metricSeries)}
+		m.families[name] = f
+	}
+	return f
+}
+
+func (f *metricFamily) with(labelValues ...string) *metricSeries {
+	key := strings.Join(labelValues, "\xff")
+	f.mu.RLock()
+	s := f.series[key]
+	f.mu.RUnlock()
+	if s != nil {
+		return s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if s = f.series[key]; s == nil {
+		s = &metricSeries{labelValues: append([]string(nil), labelValues...)}
+		if f.kind == kindHistogram {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *metricSeries) Add(delta float64) {
+	for {
+		old := atomic.LoadUint64(&s.bits)
+		if atomic.CompareAndSwapUint64(&s.bits, old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+func (s *metricSeries) Inc() { s.Add(1) }
+func (s *metricSeries) Dec() { s.Add(-1) }
+
+func (s *metricSeries) Value() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&s.bits))
+}
+
+func (f *metricFamily) observe(s *metricSeries, v float64) {
+	for i, upper := range f.buckets {
+		if v <= upper {
+			atomic.AddUint64(&s.counts[i], 1)
+			break
+		}
+	}
+	atomic.AddUint64(&s.count, 1)
+	for {
+		old := atomic.LoadUint64(&s.sumBits)
+		if atomic.CompareAndSwapUint64(&s.sumBits, old, math.Float64bits(math.Float64frombits(old)+v)) {
+			return
+		}
+	}
+}
+
+// ServeHTTP 以 Prometheus 文本格式输出全部指标，可直接挂到 /metrics
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	_, _ = m.WriteTo(w)
+}
+
+// WriteTo 按名字排序输出全部指标
+func (m *Metrics) WriteTo(w io.Writer) (int64, error) {
+	m.mu.RLock()
+	families := make([]*metricFamily, 0, len(m.families))
+	for _, f := range m.families {
+		families = append(families, f)
+	}
+	m.mu.RUnlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	cw := &countWriter{w: bufio.NewWriter(w)}
+	for _, f := range families {
+		f.writeTo(cw)
+	}
+	err := cw.w.(*bufio.Writer).Flush()
+	return cw.n, err
+}
+
+func (f *metricFamily) writeTo(w *countWriter) {
+	f.mu.RLock()
+	series := make([]*metricSeries, 0, len(f.series))
+	for _, s := range f.series {
+		series = append(series, s)
+	}
+	f.mu.RUnlock()
+	sort.Slice(series, func(i, j int) bool {
+		return strings.Join(series[i].labelValues, "\xff") < strings.Join(series[j].labelValues, "\xff")
+	})
+
+	w.WriteString("# HELP " + f.name + " " + f.help + "\n")
+	w.WriteString("# TYPE " + f.name + " " + string(f.kind) + "\n")
+	for _, s := range series {
+		if f.kind != kindHistogram {
+			w.WriteString(f.name + formatLabels(f.labels, s.labelValues, "", "") + " " + formatFloat(s.Value()) + "\n")
+			continue
+		}
+		var cumulative uint64
+		for i, upper := range f.buckets {
+			cumulative += atomic.LoadUint64(&s.counts[i])
+			w.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, "le", formatFloat(upper)) +
+				" " + strconv.FormatUint(cumulative, 10) + "\n")
+		}
+		count := atomic.LoadUint64(&s.count)
+		w.WriteString(f.name + "_bucket" + formatLabels(f.labels, s.labelValues, "le", "+Inf") +
+			" " + strconv.FormatUint(count, 10) + "\n")
+		w.WriteString(f.name + "_sum" + formatLabels(f.labels, s.labelValues, "", "") +
+			" " + formatFloat(math.Float64frombits(atomic.LoadUint64(&s.sumBits))) + "\n")
+		w.WriteString(f.name + "_count" + formatLabels(f.labels, s.labelValues, "", "") +
+			" " + strconv.FormatUint(count, 10) + "\n")
+	}
+}
+
+func formatLabels(names, values []string, extraName, extraValue string) string {
+	if len(names) == 0 && extraName == "" {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, name := range names {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(name + `="` + escapeLabel(values[i]) + `"`)
+	}
+	if extraName != "" {
+		if len(names) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(extraName + `="` + extraValue + `"`)
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabel(v string) string { return labelEscaper.Replace(v) }
+
+func formatFloat(v float64) string {
+	if math.IsInf(v, 1) {
+		return "+Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) WriteString(s string) {
+	n, _ := io.WriteString(c.w, s)
+	c.n += int64(n)
+}
+
+// serverMetrics Server 侧用到的指标
+type serverMetrics struct {
+	requests    *metricFamily
+	errors      *metricFamily
+	latency     *metricFamily
+	inFlight    *metricFamily
+	queueDepth  *metricFamily
+	rejected    *metricFamily
+	rateLimited *metricFamily
+	invalid     *metricSeries
+	readBytes   *metricSeries
+	writeBytes  *metricSeries
+	activeConns *metricSeries
+}
+
+func newServerMetrics(m *Metrics) *serverMetrics {
+	return &serverMetrics{
+		requests: m.family("fancyrpc_server_requests_total", "Total number of requests handled, by method.", kindCounter, nil, "method"),
+		errors:   m.family("fancyrpc_server_errors_total", "Total number of requests that returned an error, by method.", kindCounter, nil, "method"),
+		latency: m.family("fancyrpc_server_request_duration_seconds", "Request handling latency in seconds, by method.",
+			kindHistogram, DefaultLatencyBuckets, "method"),
+		inFlight: m.family("fancyrpc_server_in_flight_requests", "Number of requests currently being handled, by method.", kindGauge, nil, "method"),
+		queueDepth: m.family("fancyrpc_server_queue_depth", "Number of requests waiting for a concurrency slot, by method.",
+			kindGauge, nil, "method"),
+		rejected: m.family("fancyrpc_server_rejected_requests_total", "Total number of requests rejected by concurrency limits, by method.",
+			kindCounter, nil, "method"),
+		rateLimited: m.family("fancyrpc_server_rate_limited_requests_total", "Total number of requests rejected by the rate limiter, by method.",
+			kindCounter, nil, "method"),
+		invalid: m.family("fancyrpc_server_invalid_requests_total", "Total number of requests rejected before dispatch.",
+			kindCounter, nil).with(),
+		readBytes:   m.family("fancyrpc_server_read_bytes_total", "Total bytes read from client connections.", kindCounter, nil).with(),
+		writeBytes:  m.family("fancyrpc_server_written_bytes_total", "Total bytes written to client connections.", kindCounter, nil).with(),
+		activeConns: m.family("fancyrpc_server_active_connections", "Number of currently open client connections.", kindGauge, nil).with(),
+	}
+}
+
+// begin 在请求开始处理时调用，返回的函数在处理结束时调用
+func (sm *serverMetrics) begin(method string) func(err error) {
+	if sm == nil {
+		return func(error) {}
+	}
+	start := time.Now()
+	inFlight := sm.inFlight.with(method)
+	inFlight.Inc()
+	return func(err error) {
+		inFlight.Dec()
+		sm.requests.with(method).Inc()
+		if err != nil {
+			sm.errors.with(method).Inc()
+		}
+		sm.latency.observe(sm.latency.with(method), time.Since(start).Seconds())
+	}
+}
+
+// clientMetrics Client 侧用到的指标，按目标地址区分
+type clientMetrics struct {
+	requests   *metricFamily
+	errors     *metricFamily
+	latency    *metricFamily
+	inFlight   *metricSeries
+	readBytes  *metricSeries
+	writeBytes *metricSeries
+	target     string
+}
+
+func newClientMetrics(m *Metrics, target string) *clientMetrics {
+	return &clientMetrics{
+		requests: m.family("fancyrpc_client_requests_total", "Total number of calls issued, by target and method.", kindCounter, nil, "target", "method"),
+		errors:   m.family("fancyrpc_client_errors_total", "Total number of calls that failed, by target and method.", kindCounter, nil, "target", "method"),
+		latency: m.family("fancyrpc_client_request_duration_seconds", "Call latency in seconds, by target and method.",
+			kindHistogram, DefaultLatencyBuckets, "target", "method"),
+		inFlight:   m.family("fancyrpc_client_in_flight_requests", "Number of calls waiting for a reply, by target.", kindGauge, nil, "target").with(target),
+		readBytes:  m.family("fancyrpc_client_read_bytes_total", "Total bytes read from the server, by target.", kindCounter, nil, "target").with(target),
+		writeBytes: m.family("fancyrpc_client_written_bytes_total", "Total bytes written to the server, by target.", kindCounter, nil, "target").with(target),
+		target:     target,
+	}
+}
+
+func (cm *clientMetrics) observe(call *Call) {
+	cm.inFlight.Dec()
+	cm.requests.with(cm.target, call.ServiceMethod).Inc()
+	if call.Error != nil {
+		cm.errors.with(cm.target, call.ServiceMethod).Inc()
+	}
+	cm.latency.observe(cm.latency.with(cm.target, call.ServiceMethod), time.Since(call.start).Seconds())
+}
+
+// countingConn 统计连接上读写的字节数
+type countingConn struct {
+	io.ReadWriteCloser
+	read, written *metricSeries
+}
+
+func (c *countingConn) Read(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Read(p)
+	c.read.Add(float64(n))
+	return n, err
+}
+
+func (c *countingConn) Write(p []byte) (int, error) {
+	n, err := c.ReadWriteCloser.Write(p)
+	c.written.Add(float64(n))
+	return n, err
+}
+
+// countingNetConn 与 countingConn 相同，但保留 net.Conn 的其余方法
+type countingNetConn struct {
+	net.Conn
+	read, written *metricSeries
+}
+
+func (c *countingNetConn) Read(p []byte) (int, error) {
+	n, err := c.Conn.Read(p)
+	c.read.Add(float64(n))
+	return n, err
+}
+
+func (c *countingNetConn) Write(p []byte) (int, error) {
+	n, err := c.Conn.Write(p)
+	c.written.Add(float64(n))
+	return n, err
+}
@@ -0,0 +1,75 @@
+package FancyRPC
+
+import (
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_ServerAndClient(t *testing.T) {
+	m := NewMetrics()
+	_, addr := startTestServer(t, WithMetrics(m))
+	client, err := Dial("tcp", addr, &Option{Metrics: m})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply int
+	for i := 0; i < 3; i++ {
+		_assert(client.Call("Foo.Sum", &Args{Num1: i, Num2: 1}, &reply) == nil, "failed to call Foo.Sum")
+	}
+	_assert(client.Call("Foo.Missing", &Args{}, &reply) != nil, "expect error for Foo.Missing")
+
+	srv := httptest.NewServer(m)
+	defer srv.Close()
+	resp, err := srv.Client().Get(srv.URL)
+	_assert(err == nil, "scrape failed: %v", err)
+	body, _ := io.ReadAll(resp.Body)
+	_ = resp.Body.Close()
+	out := string(body)
+
+	target := client.metrics.target
+	for _, want := range []string{
+		"# TYPE fancyrpc_server_requests_total counter",
+		`fancyrpc_server_requests_total{method="Foo.Sum"} 3`,
+		`fancyrpc_server_in_flight_requests{method="Foo.Sum"} 0`,
+		`fancyrpc_server_request_duration_seconds_count{method="Foo.Sum"} 3`,
+		`fancyrpc_server_request_duration_seconds_bucket{method="Foo.Sum",le="+Inf"} 3`,
+		"fancyrpc_server_invalid_requests_total 1",
+		"fancyrpc_server_active_connections 1",
+		`fancyrpc_client_requests_total{target="` + target + `",method="Foo.Sum"} 3`,
+		`fancyrpc_client_errors_total{target="` + target + `",method="Foo.Missing"} 1`,
+		`fancyrpc_client_in_flight_requests{target="` + target + `"} 0`,
+	} {
+		_assert(strings.Contains(out, want), "missing %q in\n%s", want, out)
+	}
+	_assert(!strings.Contains(out, "fancyrpc_server_read_bytes_total 0\n") &&
+		!strings.Contains(out, "fancyrpc_server_written_bytes_total 0\n"), "expect non-zero byte counters")
+}
+
+func TestMetrics_EscapeLabels(t *testing.T) {
+	m := NewMetrics()
+	m.family("x_total", "x", kindCounter, nil, "l").with("a\"b\\c\nd").Inc()
+	var b strings.Builder
+	_, _ = m.WriteTo(&b)
+	_assert(strings.Contains(b.String(), `x_total{l="a\"b\\c\nd"} 1`), "bad escaping: %s", b.String())
+}
+
+// 连接断开时结束的调用从 pending 中删除，ctx 取消的路径不会再结束一次
+func TestMetrics_ConnectionLostDuringCall(t *testing.T) {
+	m := NewMetrics()
+	server, addr := startTestServer(t)
+	b := &Barrier{started: make(chan struct{}, 1), release: make(chan struct{})}
+	_assert(server.Register(b) == nil, "register Barrier failed")
+	defer close(b.release)
+	client, err := Dial("tcp", addr, &Option{Metrics: m})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	call := client.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+	client.terminateCalls(ErrShutdown)
+	_assert((<-call.Done).Error == ErrShutdown, "expect ErrShutdown")
+	_assert(client.removeCall(call.Seq) == nil && client.pendingCalls() == 0, "terminated calls should not stay pending")
+	_assert(client.metrics.inFlight.Value() == 0, "expect no calls in flight, got %v", client.metrics.inFlight.Value())
+}
@@ -0,0 +1,71 @@
+package FancyRPC
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+type AuditEvent struct {
+	User   string
+	Action string
+}
+
+type Audit struct {
+	events chan AuditEvent
+}
+
+// Record 收到空的 Action 时返回错误
+func (a *Audit) Record(ev AuditEvent, _ *struct{}) error {
+	if ev.Action == "" {
+		return errors.New("empty action")
+	}
+	a.events <- ev
+	return nil
+}
+
+func TestClient_Notify(t *testing.T) {
+	lg := newRecordLogger()
+	m := NewMetrics()
+	server, addr := startTestServer(t, WithLogger(lg), WithMetrics(m))
+	audit := &Audit{events: make(chan AuditEvent, 1)}
+	_assert(server.Register(audit) == nil, "register Audit failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	_assert(client.Notify("Audit.Record", AuditEvent{User: "alice", Action: "login"}) == nil, "notify failed")
+	select {
+	case ev := <-audit.events:
+		_assert(ev.User == "alice" && ev.Action == "login", "unexpected event %+v", ev)
+	case <-time.After(time.Second):
+		t.Fatal("notification was not delivered")
+	}
+	_assert(client.pendingCalls() == 0, "Notify should not register a pending call")
+
+	// 处理函数的错误和找不到的方法都不会回复，只记录在服务端
+	_assert(client.Notify("Audit.Record", AuditEvent{User: "bob"}) == nil, "notify failed")
+	_assert(client.Notify("Audit.Missing", AuditEvent{}) == nil, "notify failed")
+
+	// 后续的普通调用不会收到多余的回复
+	var reply int
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil && reply == 3, "failed to call Foo.Sum")
+
+	// 单向调用的处理函数与后续请求并发执行，等它记录完日志
+	var e logEntry
+	var ok bool
+	for deadline := time.Now().Add(time.Second); !ok && time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		e, ok = lg.find("rpc server: notification handler returned error")
+	}
+	_assert(ok && e.fields[FieldError].(error).Error() == "empty action", "expect handler error to be logged")
+	var out strings.Builder
+	_, _ = m.WriteTo(&out)
+	for _, want := range []string{
+		`fancyrpc_server_requests_total{method="Audit.Record"} 2`,
+		`fancyrpc_server_errors_total{method="Audit.Record"} 1`,
+		"fancyrpc_server_invalid_requests_total 1",
+	} {
+		_assert(strings.Contains(out.String(), want), "missing %q in\n%s", want, out.String())
+	}
+}
@@ -0,0 +1,361 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 连接池：一个 Client 的所有写入都经过 sending 锁串行写到同一个连接上，
+// Pool 对同一个地址保持多个连接，每次调用选择等待响应最少的连接。
+// 所有连接上等待的调用都达到 MaxPendingPerConn 时在后台新建连接，直到 MaxConns；
+// 超过 MinConns 的连接空闲超过 IdleTimeout 后关闭。断开的连接在下一次选择时被丢弃，
+// 健康检查把连接数补回 MinConns。
+
+// ErrPoolClosed 连接池已经关闭
+var ErrPoolClosed error = NewError(Unavailable, "rpc pool: pool is closed")
+
+// PoolConfig 连接池的配置，零值可用
+type PoolConfig struct {
+	MinConns          int           // 至少保持的连接数，默认 1
+	MaxConns          int           // 最多的连接数，小于 MinConns 时等于 MinConns
+	MaxPendingPerConn int           // 每个连接上等待的调用达到这个数时开始扩容，默认 DefaultMaxPendingPerConn
+	IdleTimeout       time.Duration // 多于 MinConns 的连接空闲超过它时关闭，为 0 时不收缩
+	CheckInterval     time.Duration // 健康检查的间隔，默认 1 秒
+}
+
+const DefaultMaxPendingPerConn = 64
+
+// Pool 所有方法都并发安全
+type Pool struct {
+	network, address string
+	opt              *Option
+	cfg              PoolConfig
+	lg               Logger
+
+	mu      sync.Mutex
+	conns   []*pooledClient
+	dialing int           // 正在建立的连接数，与 conns 一起受 MaxConns 限制
+	changed chan struct{} // conns、dialing 或 closed 变化时关闭并替换，等待连接的调用方监听它
+	closed  bool
+	stop    chan struct{}
+	wg      sync.WaitGroup
+}
+
+type pooledClient struct {
+	*Client
+	lastUsed atomic.Int64
+}
+
+// NewPool 立即建立 MinConns 个连接，第一个连接失败时返回错误，其余的由健康检查补齐
+func NewPool(network, address string, cfg PoolConfig, opts ...*Option) (*Pool, error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.MinConns <= 0 {
+		cfg.MinConns = 1
+	}
+	if cfg.MaxConns < cfg.MinConns {
+		cfg.MaxConns = cfg.MinConns
+	}
+	if cfg.MaxPendingPerConn <= 0 {
+		cfg.MaxPendingPerConn = DefaultMaxPendingPerConn
+	}
+	if cfg.CheckInterval <= 0 {
+		cfg.CheckInterval = time.Second
+	}
+	lg := opt.Logger
+	if lg == nil {
+		lg = NopLogger
+	}
+	p := &Pool{
+		network: network,
+		address: address,
+		opt:     opt,
+		cfg:     cfg,
+		lg:      lg.With(F("pool", address)),
+		stop:    make(chan struct{}),
+		changed: make(chan struct{}),
+	}
+	c, err := p.dial(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	p.conns = append(p.conns, c)
+	p.fill()
+	p.wg.Add(1)
+	go p.maintain()
+	return p, nil
+}
+
+// dial 不经过 Dial，p.opt 已经由 parseOptions 处理过，多个连接并发建立时不能再修改它
+func (p *Pool) dial(ctx context.Context) (*pooledClient, error) {
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, p.network, p.address)
+	if err != nil {
+		return nil, err
+	}
+	client, err := NewClient(conn, p.opt)
+	if err != nil {
+		_ = conn.Close()
+		return nil, err
+	}
+	c := &pooledClient{Client: client}
+	c.lastUsed.Store(time.Now().UnixNano())
+	return c, nil
+}
+
+// fill 在后台把连接数补到 MinConns
+func (p *Pool) fill() {
+	p.mu.Lock()
+	n := p.cfg.MinConns - len(p.conns) - p.dialing
+	p.mu.Unlock()
+	for i := 0; i < n; i++ {
+		p.grow()
+	}
+}
+
+// grow 在后台新建一个连接，连接数已经达到 MaxConns 时什么也不做
+func (p *Pool) grow() {
+	p.mu.Lock()
+	if p.closed || len(p.conns)+p.dialing >= p.cfg.MaxConns {
+		p.mu.Unlock()
+		return
+	}
+	p.dialing++
+	p.wg.Add(1)
+	p.mu.Unlock()
+	go func() {
+		defer p.wg.Done()
+		c, err := p.dial(context.Background())
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		p.dialing--
+		p.notify()
+		if err != nil {
+			p.lg.Log(LevelWarn, "rpc pool: dial error", F(FieldError, err))
+			return
+		}
+		if p.closed {
+			_ = c.Close()
+			return
+		}
+		p.conns = append(p.conns, c)
+	}()
+}
+
+// notify 唤醒等待连接的调用方，调用时持有 p.mu
+func (p *Pool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
+
+// get 丢弃断开的连接，返回等待响应最少的连接。没有可用连接时同步建立一个；
+// 连同正在建立的连接已经达到 MaxConns 时，等待其他调用方或后台建立的连接
+func (p *Pool) get(ctx context.Context) (*Client, error) {
+	p.mu.Lock()
+	for {
+		if p.closed {
+			p.mu.Unlock()
+			return nil, ErrPoolClosed
+		}
+		var best *pooledClient
+		bestPending := 0
+		live := p.conns[:0]
+		for _, c := range p.conns {
+			if !c.IsAvailable() {
+				_ = c.Close()
+				continue
+			}
+			live = append(live, c)
+			if n := c.pendingCalls(); best == nil || n < bestPending {
+				best, bestPending = c, n
+			}
+		}
+		for i := len(live); i < len(p.conns); i++ {
+			p.conns[i] = nil
+		}
+		p.conns = live
+		if best != nil {
+			p.mu.Unlock()
+			if bestPending >= p.cfg.MaxPendingPerConn {
+				p.grow()
+			}
+			if len(live) < p.cfg.MinConns {
+				p.fill()
+			}
+			best.lastUsed.Store(time.Now().UnixNano())
+			return best.Client, nil
+		}
+		if p.dialing < p.cfg.MaxConns {
+			break
+		}
+		changed := p.changed
+		p.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("rpc pool: get connection: %w", ctx.Err())
+		}
+		p.mu.Lock()
+	}
+	p.dialing++
+	p.mu.Unlock()
+
+	c, err := p.dial(ctx)
+	p.mu.Lock()
+	p.dialing--
+	p.notify()
+	if err != nil {
+		p.mu.Unlock()
+		return nil, err
+	}
+	if p.closed {
+		p.mu.Unlock()
+		_ = c.Close()
+		return nil, ErrPoolClosed
+	}
+	p.conns = append(p.conns, c)
+	p.mu.Unlock()
+	p.fill()
+	return c.Client, nil
+}
+
+// maintain 定期补齐 MinConns，并关闭多余的空闲连接
+func (p *Pool) maintain() {
+	defer p.wg.Done()
+	ticker := time.NewTicker(p.cfg.CheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-p.stop:
+			return
+		}
+		p.reapIdle()
+		p.fill()
+	}
+}
+
+func (p *Pool) reapIdle() {
+	if p.cfg.IdleTimeout <= 0 {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	live := p.conns[:0]
+	for _, c := range p.conns {
+		idle := now.Sub(time.Unix(0, c.lastUsed.Load()))
+		switch {
+		case !c.IsAvailable():
+			_ = c.Close()
+		case len(live) >= p.cfg.MinConns && idle >= p.cfg.IdleTimeout && c.pendingCalls() == 0:
+			p.lg.Log(LevelInfo, "rpc pool: closing idle connection", F("idle", idle))
+			_ = c.Close()
+		default:
+			live = append(live, c)
+		}
+	}
+	for i := len(live); i < len(p.conns); i++ {
+		p.conns[i] = nil
+	}
+	p.conns = live
+}
+
+// Size 返回当前的连接数
+func (p *Pool) Size() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Call 与 Client.Call 相同
+func (p *Pool) Call(serviceMethod string, args, reply interface{}) error {
+	return p.CallContext(context.Background(), serviceMethod, args, reply)
+}
+
+// CallContext 与 Client.CallContext 相同。选中的连接恰好在发送之前被关闭时换一个连接重试，请求不会被发送两次。
+func (p *Pool) CallContext(ctx context.Context, serviceMethod string, args, reply interface{}) error {
+	for {
+		client, err := p.get(ctx)
+		if err != nil {
+			return err
+		}
+		err = client.CallContext(ctx, serviceMethod, args, reply)
+		// ErrShutdown 只在登记调用时返回，此时请求还没有写出
+		if err != ErrShutdown || ctx.Err() != nil {
+			return err
+		}
+	}
+}
+
+// Go 与 Client.Go 相同，连接池已关闭或无法建立连接时返回的 Call 立即以错误结束
+func (p *Pool) Go(serviceMethod string, args, reply interface{}, done chan *Call) *Call {
+	client, err := p.get(context.Background())
+	if err != nil {
+		if done == nil {
+			done = make(chan *Call, 1)
+		}
+		call := &Call{ServiceMethod: serviceMethod, Args: args, Rely: reply, Error: err, Done: done}
+		// done 可能没有缓冲
+		go call.done()
+		return call
+	}
+	return client.Go(serviceMethod, args, reply, done)
+}
+
+// Notify 与 Client.Notify 相同
+func (p *Pool) Notify(serviceMethod string, args interface{}) error {
+	client, err := p.get(context.Background())
+	if err != nil {
+		return err
+	}
+	return client.Notify(serviceMethod, args)
+}
+
+// Stream 与 Client.Stream 相同，流在整个生命周期内使用同一个连接
+func (p *Pool) Stream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	client, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.Stream(ctx, serviceMethod, args, reply)
+}
+
+// OpenStream 与 Client.OpenStream 相同
+func (p *Pool) OpenStream(ctx context.Context, serviceMethod string, reply interface{}) (*ClientStream, error) {
+	client, err := p.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.OpenStream(ctx, serviceMethod, reply)
+}
+
+// Close 关闭所有连接，进行中的调用以连接断开的错误结束
+func (p *Pool) Close() error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return ErrPoolClosed
+	}
+	p.closed = true
+	conns := p.conns
+	p.conns = nil
+	close(p.stop)
+	p.notify()
+	p.mu.Unlock()
+	var errs []error
+	for _, c := range conns {
+		if err := c.Close(); err != nil && err != ErrShutdown {
+			errs = append(errs, err)
+		}
+	}
+	p.wg.Wait()
+	return errors.Join(errs...)
+}
@@ -0,0 +1,152 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func waitFor(cond func() bool) bool {
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return true
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	return false
+}
+
+func TestPool_GrowsByPending(t *testing.T) {
+	server, addr := startTestServer(t)
+	b := &Barrier{started: make(chan struct{}, 10), release: make(chan struct{})}
+	_assert(server.Register(b) == nil, "register Barrier failed")
+	p, err := NewPool("tcp", addr, PoolConfig{MaxConns: 2, MaxPendingPerConn: 1})
+	_assert(err == nil, "new pool failed: %v", err)
+	defer func() { _ = p.Close() }()
+
+	first := p.Go("Barrier.Wait", 1, new(int), nil)
+	<-b.started
+	// 唯一的连接上已经有一个等待中的调用，下一次选择时扩容
+	var reply int
+	_assert(p.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil, "call failed")
+	_assert(waitFor(func() bool { return p.Size() == 2 }), "expect pool to grow to 2, got %d", p.Size())
+
+	second := p.Go("Barrier.Wait", 2, new(int), nil)
+	<-b.started
+	p.mu.Lock()
+	for _, c := range p.conns {
+		_assert(c.pendingCalls() == 1, "expect calls spread over connections, got %d", c.pendingCalls())
+	}
+	p.mu.Unlock()
+
+	close(b.release)
+	_assert((<-first.Done).Error == nil && (<-second.Done).Error == nil, "blocked calls failed")
+	_ = p.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(p.Size() == 2, "pool must not exceed MaxConns, got %d", p.Size())
+}
+
+func TestPool_ReplacesBrokenConnection(t *testing.T) {
+	_, addr := startTestServer(t)
+	p, err := NewPool("tcp", addr, PoolConfig{MinConns: 2, CheckInterval: 10 * time.Millisecond})
+	_assert(err == nil, "new pool failed: %v", err)
+	defer func() { _ = p.Close() }()
+	_assert(waitFor(func() bool { return p.Size() == 2 }), "expect 2 connections, got %d", p.Size())
+
+	p.mu.Lock()
+	broken := p.conns[0].Client
+	p.mu.Unlock()
+	_ = broken.Close()
+
+	var reply int
+	for i := 0; i < 4; i++ {
+		err = p.Call("Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+		_assert(err == nil && reply == i+1, "call %d failed: %v", i, err)
+	}
+	_assert(waitFor(func() bool {
+		p.mu.Lock()
+		defer p.mu.Unlock()
+		for _, c := range p.conns {
+			if c.Client == broken {
+				return false
+			}
+		}
+		return len(p.conns) == 2
+	}), "broken connection not replaced")
+}
+
+func TestPool_ShrinksIdle(t *testing.T) {
+	_, addr := startTestServer(t)
+	p, err := NewPool("tcp", addr, PoolConfig{MaxConns: 3, IdleTimeout: 50 * time.Millisecond, CheckInterval: 10 * time.Millisecond})
+	_assert(err == nil, "new pool failed: %v", err)
+	p.grow()
+	p.grow()
+	_assert(waitFor(func() bool { return p.Size() == 3 }), "expect 3 connections, got %d", p.Size())
+	_assert(waitFor(func() bool { return p.Size() == 1 }), "expect idle connections closed, got %d", p.Size())
+
+	_assert(p.Close() == nil, "close failed")
+	var reply int
+	err = p.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == ErrPoolClosed, "expect ErrPoolClosed, got %v", err)
+}
+
+// countingListener 统计服务端接受的连接数
+type countingListener struct {
+	net.Listener
+	accepted atomic.Int32
+}
+
+func (l *countingListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err == nil {
+		l.accepted.Add(1)
+	}
+	return conn, err
+}
+
+func TestPool_DialRespectsMaxConns(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	inner, _ := net.Listen("tcp", "127.0.0.1:0")
+	l := &countingListener{Listener: inner}
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	p, err := NewPool("tcp", l.Addr().String(), PoolConfig{MaxConns: 2, MaxPendingPerConn: 1000})
+	_assert(err == nil, "new pool failed: %v", err)
+	defer func() { _ = p.Close() }()
+	_ = p.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, new(int))
+	p.mu.Lock()
+	_ = p.conns[0].Close()
+	p.mu.Unlock()
+
+	// 唯一的连接断开后大量调用同时到来，同步建立的连接不能超过 MaxConns
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var reply int
+			err := p.Call("Foo.Sum", &Args{Num1: i, Num2: 1}, &reply)
+			_assert(err == nil && reply == i+1, "call %d failed: %v", i, err)
+		}(i)
+	}
+	wg.Wait()
+	_assert(l.accepted.Load() <= 3, "expect at most MaxConns new connections, got %d", l.accepted.Load()-1)
+
+	// 建立连接时使用调用方的 ctx
+	p.mu.Lock()
+	for _, c := range p.conns {
+		_ = c.Close()
+	}
+	p.mu.Unlock()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	err = p.CallContext(ctx, "Foo.Sum", &Args{}, new(int))
+	_assert(errors.Is(err, context.Canceled), "expect context canceled, got %v", err)
+}
@@ -0,0 +1,155 @@
+package FancyRPC
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+)
+
+// 令牌桶限流：每个调用方一个桶，配置了单独限额的方法再按调用方各有一个桶。
+// 请求在读取 body 之前检查，超出限额时以 ErrQuotaExceeded 拒绝。限额可以在运行时修改，立即生效。
+
+// ErrQuotaExceeded 调用方超出了限额
+var ErrQuotaExceeded error = NewError(ResourceExhausted, "rpc server: quota exceeded")
+
+// Limit 每秒补充 Rate 个令牌，桶中最多 Burst 个。Rate 不大于 0 表示不限制。
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+func (l Limit) unlimited() bool { return l.Rate <= 0 }
+
+// RateLimitKeyFunc 从请求中取出调用方的标识，返回相同标识的请求共用一个桶
+type RateLimitKeyFunc func(info *RequestInfo) string
+
+// RateLimitByRemoteAddr 按对端地址的 host 部分限流，同一台机器上的连接共用限额
+func RateLimitByRemoteAddr(info *RequestInfo) string {
+	host, _, err := net.SplitHostPort(info.RemoteAddr)
+	if err != nil {
+		return info.RemoteAddr
+	}
+	return host
+}
+
+// RateLimitByPrincipal 按 Authenticator 认证出的身份限流
+func RateLimitByPrincipal(info *RequestInfo) string {
+	return info.Principal
+}
+
+// RateLimitByMetadata 按请求 metadata 中 key 的值限流，例如租户 ID；没有该键的请求共用一个桶
+func RateLimitByMetadata(key string) RateLimitKeyFunc {
+	return func(info *RequestInfo) string {
+		return info.Metadata.Get(key)
+	}
+}
+
+// RateLimiter 可以在多个 Server 之间共享，所有方法都并发安全
+type RateLimiter struct {
+	key RateLimitKeyFunc
+
+	mu        sync.Mutex
+	limit     Limit
+	methods   map[string]Limit
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucketKey struct {
+	id     string
+	method string // 使用默认限额的方法为空
+}
+
+type tokenBucket struct {
+	limit  Limit
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter 所有方法默认使用 limit，可以用 SetMethodLimit 为单个方法设置不同的限额
+func NewRateLimiter(key RateLimitKeyFunc, limit Limit) *RateLimiter {
+	return &RateLimiter{
+		key:       key,
+		limit:     limit,
+		methods:   make(map[string]Limit),
+		buckets:   make(map[bucketKey]*tokenBucket),
+		lastSweep: time.Now(),
+		now:       time.Now,
+	}
+}
+
+// WithRateLimiter 每个请求在读取 body 之前经过 rl 限流
+func WithRateLimiter(rl *RateLimiter) ServerOption {
+	return func(server *Server) {
+		server.rateLimiter = rl
+	}
+}
+
+// SetLimit 修改默认限额
+func (rl *RateLimiter) SetLimit(l Limit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.limit = l
+}
+
+// SetMethodLimit 为 serviceMethod 单独设置限额，它不再消耗默认桶中的令牌
+func (rl *RateLimiter) SetMethodLimit(serviceMethod string, l Limit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.methods[serviceMethod] = l
+}
+
+// RemoveMethodLimit 让 serviceMethod 重新使用默认限额
+func (rl *RateLimiter) RemoveMethodLimit(serviceMethod string) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	delete(rl.methods, serviceMethod)
+}
+
+// Allow 消耗一个令牌，没有令牌时返回 false
+func (rl *RateLimiter) Allow(info *RequestInfo) bool {
+	id := rl.key(info)
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := rl.now()
+	key, limit := bucketKey{id: id}, rl.limit
+	if l, ok := rl.methods[info.ServiceMethod]; ok {
+		key.method, limit = info.ServiceMethod, l
+	}
+	if limit.unlimited() {
+		return true
+	}
+	rl.sweep(now)
+	b := rl.buckets[key]
+	if b == nil {
+		b = &tokenBucket{limit: limit, tokens: float64(limit.Burst), last: now}
+		rl.buckets[key] = b
+	}
+	if b.limit != limit {
+		// 限额被修改过，已有的令牌不超过新的 Burst
+		b.limit = limit
+		b.tokens = math.Min(b.tokens, float64(limit.Burst))
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*limit.Rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// sweep 每分钟清理一次已经补满的桶，避免调用方很多时桶无限增长
+func (rl *RateLimiter) sweep(now time.Time) {
+	if now.Sub(rl.lastSweep) < time.Minute {
+		return
+	}
+	rl.lastSweep = now
+	for key, b := range rl.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.limit.Rate >= float64(b.limit.Burst) {
+			delete(rl.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter_TokenBucket(t *testing.T) {
+	now := time.Unix(0, 0)
+	rl := NewRateLimiter(RateLimitByRemoteAddr, Limit{Rate: 1, Burst: 2})
+	rl.now = func() time.Time { return now }
+	a := &RequestInfo{ServiceMethod: "Foo.Sum", RemoteAddr: "10.0.0.1:1234"}
+	a2 := &RequestInfo{ServiceMethod: "Foo.Sum", RemoteAddr: "10.0.0.1:5678"}
+	b := &RequestInfo{ServiceMethod: "Foo.Sum", RemoteAddr: "10.0.0.2:1234"}
+
+	_assert(rl.Allow(a) && rl.Allow(a2), "burst should allow two requests")
+	_assert(!rl.Allow(a), "same host shares one bucket")
+	_assert(rl.Allow(b), "other hosts have their own bucket")
+	now = now.Add(time.Second)
+	_assert(rl.Allow(a) && !rl.Allow(a), "one token per second")
+
+	// 单独限额的方法使用自己的桶
+	rl.SetMethodLimit("Foo.Slow", Limit{Rate: 1, Burst: 1})
+	slow := &RequestInfo{ServiceMethod: "Foo.Slow", RemoteAddr: "10.0.0.1:1"}
+	_assert(rl.Allow(slow) && !rl.Allow(slow), "method limit should apply")
+	rl.SetMethodLimit("Foo.Slow", Limit{})
+	_assert(rl.Allow(slow) && rl.Allow(slow), "zero rate means unlimited")
+	rl.RemoveMethodLimit("Foo.Slow")
+	_assert(!rl.Allow(slow), "removed override falls back to the default bucket")
+
+	// 运行时修改默认限额立即生效
+	rl.SetLimit(Limit{Rate: 100, Burst: 5})
+	now = now.Add(time.Second)
+	for i := 0; i < 5; i++ {
+		_assert(rl.Allow(a), "new burst should apply, request %d", i)
+	}
+	_assert(!rl.Allow(a), "new burst should be the cap")
+}
+
+type Whoami struct{}
+
+func (Whoami) Get(ctx context.Context, _ int, reply *string) error {
+	*reply = PrincipalFromContext(ctx) + "/" + MetadataFromContext(ctx).Get("tenant")
+	return nil
+}
+
+func TestRateLimiter_EndToEnd(t *testing.T) {
+	m := NewMetrics()
+	rl := NewRateLimiter(RateLimitByPrincipal, Limit{Rate: 0.001, Burst: 2})
+	auth := func(info *RequestInfo) (string, error) {
+		token := info.Metadata.Get("token")
+		if token == "" {
+			return "", errors.New("missing token")
+		}
+		return "user-" + token, nil
+	}
+	server, addr := startTestServer(t, WithMetrics(m), WithAuthenticator(auth), WithRateLimiter(rl))
+	_assert(server.Register(Whoami{}) == nil, "register Whoami failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call("Whoami.Get", 0, &reply)
+	_assert(err != nil && err.Error() == "missing token", "expect authentication error, got %v", err)
+
+	alice := ContextWithMetadata(context.Background(), Metadata{"token": "alice", "tenant": "acme"})
+	bob := ContextWithMetadata(context.Background(), Metadata{"token": "bob"})
+	_assert(client.CallContext(alice, "Whoami.Get", 0, &reply) == nil && reply == "user-alice/acme", "unexpected reply %q", reply)
+	_assert(client.CallContext(alice, "Whoami.Get", 0, &reply) == nil, "second call should be within the burst")
+	err = client.CallContext(alice, "Whoami.Get", 0, &reply)
+	_assert(err != nil && err.Error() == ErrQuotaExceeded.Error(), "expect quota exceeded, got %v", err)
+	_assert(client.CallContext(bob, "Whoami.Get", 0, &reply) == nil && reply == "user-bob/", "other principals are not affected")
+
+	// 提高限额后不需要重启
+	rl.SetMethodLimit("Whoami.Get", Limit{Rate: 1000, Burst: 10})
+	_assert(client.CallContext(alice, "Whoami.Get", 0, &reply) == nil, "call after raising the limit failed")
+
+	var out strings.Builder
+	_, _ = m.WriteTo(&out)
+	_assert(strings.Contains(out.String(), `fancyrpc_server_rate_limited_requests_total{method="Whoami.Get"} 1`),
+		"missing rate limited counter in\n%s", out.String())
+}
@@ -0,0 +1,264 @@
+package FancyRPC
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"time"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 反射服务：WithReflection 时自动注册名为 "Reflection" 的服务，工具可以在运行时查询
+// 服务端有哪些服务、方法，以及参数和回复的结构。描述信息都是普通的结构体，gob、msgpack 可以直接传输；
+// protobuf 的 body 必须是 proto.Message，因此另外提供 Reflection.Schema，以 JSON 的形式返回同样的内容。
+
+// ReflectionService 反射服务注册的名字
+const ReflectionService = "Reflection"
+
+// TypeDesc 描述一个类型，由 reflect 得到
+type TypeDesc struct {
+	Name   string      // reflect.Type.String()，例如 "FancyRPC.Args"、"[]int"
+	Kind   string      // reflect.Kind 的名字，例如 "struct"、"ptr"、"slice"
+	Len    int         `json:",omitempty"` // 数组的长度
+	Key    *TypeDesc   `json:",omitempty"` // map 的键
+	Elem   *TypeDesc   `json:",omitempty"` // 指针、切片、数组、map 的元素
+	Fields []FieldDesc `json:",omitempty"` // 结构体导出的字段
+	// Recursive 递归的结构体只在最外层展开，内层的引用只有名字和种类
+	Recursive bool `json:",omitempty"`
+}
+
+// FieldDesc 描述结构体的一个导出字段
+type FieldDesc struct {
+	Name     string
+	Tag      string `json:",omitempty"`
+	Embedded bool   `json:",omitempty"`
+	Type     *TypeDesc
+}
+
+// MethodDesc 描述一个方法，流式方法的 ArgType、ReplyType 是流中元素的类型
+type MethodDesc struct {
+	Name         string
+	ArgType      *TypeDesc
+	ReplyType    *TypeDesc // 去掉了 reply 参数的指针
+	ClientStream bool      `json:",omitempty"`
+	ServerStream bool      `json:",omitempty"`
+}
+
+// ServiceDesc 描述一个服务，方法按名字排序
+type ServiceDesc struct {
+	Name    string
+	Methods []MethodDesc
+}
+
+// WithReflection 注册反射服务 Reflection
+func WithReflection() ServerOption {
+	return func(server *Server) {
+		server.reflection = true
+	}
+}
+
+// Reflection 反射服务，由 WithReflection 注册
+type Reflection struct {
+	server *Server
+}
+
+// ListServices 返回名为 name 的服务，name 为空时返回所有服务，按名字排序
+func (r *Reflection) ListServices(name string, reply *[]ServiceDesc) error {
+	services, err := r.server.describeServices(name)
+	*reply = services
+	return err
+}
+
+// DescribeMethod 返回 "Service.Method" 的描述
+func (r *Reflection) DescribeMethod(serviceMethod string, reply *MethodDesc) error {
+	_, mtype, err := r.server.findService(serviceMethod)
+	if err != nil {
+		return err
+	}
+	*reply = describeMethod(mtype)
+	return nil
+}
+
+// Schema 与 ListServices 相同，结果编码为 JSON，供 protobuf 编码的客户端使用
+func (r *Reflection) Schema(name *wrapperspb.StringValue, reply *wrapperspb.BytesValue) error {
+	services, err := r.server.describeServices(name.GetValue())
+	if err != nil {
+		return err
+	}
+	reply.Value, err = json.Marshal(services)
+	return err
+}
+
+func (server *Server) describeServices(name string) ([]ServiceDesc, error) {
+	if name != "" {
+		svci, ok := server.serviceMap.Load(name)
+		if !ok {
+			return nil, Errorf(NotFound, "rpc server: can't find service %s", name)
+		}
+		return []ServiceDesc{describeService(svci.(*service))}, nil
+	}
+	var services []ServiceDesc
+	server.serviceMap.Range(func(_, svci interface{}) bool {
+		services = append(services, describeService(svci.(*service)))
+		return true
+	})
+	sort.Slice(services, func(i, j int) bool { return services[i].Name < services[j].Name })
+	return services, nil
+}
+
+func describeService(s *service) ServiceDesc {
+	desc := ServiceDesc{Name: s.name, Methods: make([]MethodDesc, 0, len(s.method))}
+	for _, mtype := range s.method {
+		desc.Methods = append(desc.Methods, describeMethod(mtype))
+	}
+	sort.Slice(desc.Methods, func(i, j int) bool { return desc.Methods[i].Name < desc.Methods[j].Name })
+	return desc
+}
+
+func describeMethod(m *methodType) MethodDesc {
+	argType, replyType := m.ArgType, m.ReplyType.Elem()
+	if m.clientStream {
+		argType = reflect.Zero(m.ArgType).Interface().(recvStreamer).elemType()
+	}
+	if m.serverStream {
+		replyType = reflect.Zero(m.ReplyType).Interface().(serverStreamer).elemType()
+	}
+	return MethodDesc{
+		Name:         m.method.Name,
+		ArgType:      describeType(argType, make(map[reflect.Type]bool)),
+		ReplyType:    describeType(replyType, make(map[reflect.Type]bool)),
+		ClientStream: m.clientStream,
+		ServerStream: m.serverStream,
+	}
+}
+
+// describeType expanding 记录正在展开的结构体，遇到递归时只给出名字和种类
+func describeType(t reflect.Type, expanding map[reflect.Type]bool) *TypeDesc {
+	desc := &TypeDesc{Name: t.String(), Kind: t.Kind().String()}
+	switch t.Kind() {
+	case reflect.Ptr, reflect.Slice:
+		desc.Elem = describeType(t.Elem(), expanding)
+	case reflect.Array:
+		desc.Len = t.Len()
+		desc.Elem = describeType(t.Elem(), expanding)
+	case reflect.Map:
+		desc.Key = describeType(t.Key(), expanding)
+		desc.Elem = describeType(t.Elem(), expanding)
+	case reflect.Struct:
+		if expanding[t] {
+			desc.Recursive = true
+			return desc
+		}
+		expanding[t] = true
+		defer delete(expanding, t)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			desc.Fields = append(desc.Fields, FieldDesc{
+				Name:     f.Name,
+				Tag:      string(f.Tag),
+				Embedded: f.Anonymous,
+				Type:     describeType(f.Type, expanding),
+			})
+		}
+	}
+	return desc
+}
+
+// basicTypes 基本类型的种类名对应的类型
+var basicTypes = map[string]reflect.Type{}
+
+// knownTypes 字段不能描述其编码方式的具名类型，按 TypeDesc.Name 直接使用本地的类型
+var knownTypes = map[string]reflect.Type{
+	"time.Time": reflect.TypeOf(time.Time{}),
+}
+
+func init() {
+	for _, v := range []interface{}{
+		false, int(0), int8(0), int16(0), int32(0), int64(0),
+		uint(0), uint8(0), uint16(0), uint32(0), uint64(0), uintptr(0),
+		float32(0), float64(0), complex64(0), complex128(0), "",
+	} {
+		t := reflect.TypeOf(v)
+		basicTypes[t.Kind().String()] = t
+	}
+	basicTypes[reflect.Interface.String()] = reflect.TypeOf((*interface{})(nil)).Elem()
+}
+
+// Type 按描述构造一个结构相同的匿名类型，客户端没有服务端的类型定义时可以用它编解码参数和回复。
+// 具名类型会变成匿名类型，方法和未导出的字段都会丢失；递归的类型无法构造。
+func (d *TypeDesc) Type() (t reflect.Type, err error) {
+	// 描述来自远端，字段名不合法、数组过大等情况 reflect 会 panic，转换成错误返回
+	defer func() {
+		if r := recover(); r != nil {
+			t, err = nil, fmt.Errorf("rpc: can't build type %s: %v", d.Name, r)
+		}
+	}()
+	if t, ok := knownTypes[d.Name]; ok {
+		return t, nil
+	}
+	if t, ok := basicTypes[d.Kind]; ok {
+		return t, nil
+	}
+	switch d.Kind {
+	case reflect.Ptr.String(), reflect.Slice.String(), reflect.Array.String():
+		if d.Elem == nil {
+			return nil, fmt.Errorf("rpc: type %s has no element type", d.Name)
+		}
+		elem, err := d.Elem.Type()
+		if err != nil {
+			return nil, err
+		}
+		switch d.Kind {
+		case reflect.Ptr.String():
+			return reflect.PointerTo(elem), nil
+		case reflect.Slice.String():
+			return reflect.SliceOf(elem), nil
+		default:
+			if d.Len < 0 {
+				return nil, fmt.Errorf("rpc: array type %s has negative length %d", d.Name, d.Len)
+			}
+			return reflect.ArrayOf(d.Len, elem), nil
+		}
+	case reflect.Map.String():
+		if d.Key == nil || d.Elem == nil {
+			return nil, fmt.Errorf("rpc: type %s has no key or element type", d.Name)
+		}
+		key, err := d.Key.Type()
+		if err != nil {
+			return nil, err
+		}
+		if !key.Comparable() {
+			return nil, fmt.Errorf("rpc: map type %s has non-comparable key %s", d.Name, key)
+		}
+		elem, err := d.Elem.Type()
+		if err != nil {
+			return nil, err
+		}
+		return reflect.MapOf(key, elem), nil
+	case reflect.Struct.String():
+		if d.Recursive {
+			return nil, fmt.Errorf("rpc: recursive type %s is not supported", d.Name)
+		}
+		fields := make([]reflect.StructField, 0, len(d.Fields))
+		for _, f := range d.Fields {
+			if f.Type == nil {
+				return nil, fmt.Errorf("rpc: field %s.%s has no type", d.Name, f.Name)
+			}
+			t, err := f.Type.Type()
+			if err != nil {
+				return nil, err
+			}
+			// reflect.StructOf 不支持带方法的嵌入字段，例如 time.Time，作为普通字段处理，字段名不变
+			embedded := f.Embedded && t.NumMethod() == 0 && reflect.PointerTo(t).NumMethod() == 0
+			fields = append(fields, reflect.StructField{Name: f.Name, Type: t, Tag: reflect.StructTag(f.Tag), Anonymous: embedded})
+		}
+		return reflect.StructOf(fields), nil
+	default:
+		return nil, fmt.Errorf("rpc: unsupported kind %s of type %s", d.Kind, d.Name)
+	}
+}
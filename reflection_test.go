@@ -0,0 +1,163 @@
+package FancyRPC
+
+import (
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"FancyRPC/codec"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+type Node struct {
+	Value    int `json:"value"`
+	Children []*Node
+	Labels   map[string][2]byte
+	parent   *Node
+}
+
+type Tree struct{}
+
+func (Tree) Depth(root *Node, depth *int) error {
+	return nil
+}
+
+func startReflectionServer(t *testing.T) string {
+	server := NewServer(WithReflection())
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	_assert(server.Register(Rows{}) == nil, "register Rows failed")
+	_assert(server.Register(Tree{}) == nil, "register Tree failed")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+func TestReflection(t *testing.T) {
+	addr := startReflectionServer(t)
+	for _, ct := range []string{codec.GobType, codec.MsgpackType} {
+		client, err := Dial("tcp", addr, &Option{CodecType: ct})
+		_assert(err == nil, "%s: dial failed: %v", ct, err)
+
+		var services []ServiceDesc
+		err = client.Call("Reflection.ListServices", "", &services)
+		_assert(err == nil && len(services) == 4, "%s: unexpected services %+v %v", ct, services, err)
+		names := []string{services[0].Name, services[1].Name, services[2].Name, services[3].Name}
+		_assert(names[0] == "Foo" && names[1] == ReflectionService && names[2] == "Rows" && names[3] == "Tree",
+			"%s: unexpected service names %v", ct, names)
+
+		sum := services[0].Methods[0]
+		_assert(sum.Name == "Sum" && sum.ArgType.Name == "FancyRPC.Args" && sum.ArgType.Kind == "struct" &&
+			len(sum.ArgType.Fields) == 2 && sum.ArgType.Fields[1].Name == "Num2" && sum.ArgType.Fields[1].Type.Kind == "int" &&
+			sum.ReplyType.Name == "int", "%s: unexpected Foo.Sum %+v", ct, sum)
+
+		var m MethodDesc
+		err = client.Call("Reflection.DescribeMethod", "Rows.Echo", &m)
+		_assert(err == nil && m.ClientStream && m.ServerStream && m.ArgType.Name == "FancyRPC.Row" && m.ReplyType.Name == "FancyRPC.Row",
+			"%s: unexpected Rows.Echo %+v %v", ct, m, err)
+
+		err = client.Call("Reflection.DescribeMethod", "Tree.Depth", &m)
+		_assert(err == nil, "%s: describe Tree.Depth failed: %v", ct, err)
+		node := m.ArgType.Elem
+		_assert(m.ArgType.Kind == "ptr" && node.Name == "FancyRPC.Node" && len(node.Fields) == 3, "%s: unexpected Node %+v", ct, node)
+		_assert(node.Fields[0].Tag == `json:"value"`, "%s: unexpected tag %q", ct, node.Fields[0].Tag)
+		child := node.Fields[1].Type.Elem.Elem
+		_assert(child.Name == "FancyRPC.Node" && child.Recursive && child.Fields == nil, "%s: recursive type expanded %+v", ct, child)
+		labels := node.Fields[2].Type
+		_assert(labels.Kind == "map" && labels.Key.Kind == "string" && labels.Elem.Kind == "array" && labels.Elem.Len == 2,
+			"%s: unexpected Labels %+v", ct, labels)
+
+		err = client.Call("Reflection.ListServices", "Nope", &services)
+		_assert(CodeOf(err) == NotFound, "%s: expect NotFound, got %v", ct, err)
+		_ = client.Close()
+	}
+}
+
+func TestReflection_Protobuf(t *testing.T) {
+	addr := startReflectionServer(t)
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.ProtobufType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	reply := new(wrapperspb.BytesValue)
+	err = client.Call("Reflection.Schema", wrapperspb.String("Foo"), reply)
+	_assert(err == nil, "schema failed: %v", err)
+	var services []ServiceDesc
+	_assert(json.Unmarshal(reply.GetValue(), &services) == nil, "unexpected schema %s", reply.GetValue())
+	_assert(len(services) == 1 && services[0].Methods[0].ArgType.Fields[0].Name == "Num1", "unexpected schema %s", reply.GetValue())
+}
+
+type Event struct {
+	Args `msgpack:"args"`
+	At   time.Time
+	Tags map[string][]uint16
+}
+
+func (Tree) Echo(ev Event, reply *Event) error {
+	*reply = ev
+	return nil
+}
+
+func TestTypeDesc_Type(t *testing.T) {
+	addr := startReflectionServer(t)
+	for _, ct := range []string{codec.GobType, codec.MsgpackType} {
+		client, err := Dial("tcp", addr, &Option{CodecType: ct})
+		_assert(err == nil, "%s: dial failed: %v", ct, err)
+
+		// 只根据反射服务的描述构造参数和回复，不使用 Event 类型
+		var m MethodDesc
+		err = client.Call("Reflection.DescribeMethod", "Tree.Echo", &m)
+		_assert(err == nil, "%s: describe failed: %v", ct, err)
+		argType, err := m.ArgType.Type()
+		_assert(err == nil, "%s: build arg type failed: %v", ct, err)
+		replyType, err := m.ReplyType.Type()
+		_assert(err == nil, "%s: build reply type failed: %v", ct, err)
+
+		argv := reflect.New(argType)
+		in := `{"Num1":1,"Num2":2,"At":"2024-01-02T03:04:05Z","Tags":{"a":[1,2]}}`
+		_assert(json.Unmarshal([]byte(in), argv.Interface()) == nil, "%s: unmarshal failed", ct)
+		replyv := reflect.New(replyType)
+		err = client.Call("Tree.Echo", argv.Elem().Interface(), replyv.Interface())
+		_assert(err == nil, "%s: call failed: %v", ct, err)
+		out, _ := json.Marshal(replyv.Interface())
+		_assert(string(out) == in, "%s: unexpected reply %s", ct, out)
+		_ = client.Close()
+	}
+
+	_, err := describeType(reflect.TypeOf(Node{}), make(map[reflect.Type]bool)).Type()
+	_assert(err != nil, "expect recursive type rejected")
+
+	// 带方法的嵌入字段按普通字段构造
+	type stamped struct {
+		ID int
+		time.Time
+	}
+	st, err := describeType(reflect.TypeOf(stamped{}), make(map[reflect.Type]bool)).Type()
+	_assert(err == nil, "build embedded type failed: %v", err)
+	f, ok := st.FieldByName("Time")
+	_assert(ok && f.Type == reflect.TypeOf(time.Time{}) && !f.Anonymous, "unexpected field %+v", f)
+
+	// 远端发来的畸形描述返回错误，不会 panic
+	intDesc := &TypeDesc{Name: "int", Kind: "int"}
+	sliceDesc := &TypeDesc{Name: "[]int", Kind: "slice", Elem: intDesc}
+	badCases := []struct {
+		desc *TypeDesc
+		err  string
+	}{
+		{&TypeDesc{Name: "bad", Kind: "struct", Fields: []FieldDesc{{Name: "lower", Type: intDesc}}}, "can't build type bad"},
+		{&TypeDesc{Name: "[-1]int", Kind: "array", Len: -1, Elem: intDesc}, "negative length -1"},
+		{&TypeDesc{Name: "map[[]int]int", Kind: "map", Key: sliceDesc, Elem: intDesc}, "non-comparable key []int"},
+		{&TypeDesc{Name: "nested", Kind: "struct", Fields: []FieldDesc{{Name: "M", Type: &TypeDesc{Name: "map[[]int]int", Kind: "map", Key: sliceDesc, Elem: intDesc}}}},
+			"non-comparable key []int"},
+		{&TypeDesc{Name: "huge", Kind: "array", Len: 1 << 62, Elem: &TypeDesc{Name: "[1024]int", Kind: "array", Len: 1024, Elem: intDesc}}, "can't build type huge"},
+	}
+	for _, c := range badCases {
+		_, err := c.desc.Type()
+		_assert(err != nil && strings.Contains(err.Error(), c.err), "%s: expect error %q, got %v", c.desc.Name, c.err, err)
+	}
+}
@@ -0,0 +1,125 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// Hub 服务端，收到订阅后反向调用客户端的 Push.Deliver
+type Hub struct{}
+
+func (Hub) Subscribe(ctx context.Context, n int, delivered *int) error {
+	caller := CallerFromContext(ctx)
+	if caller == nil {
+		return errors.New("no caller")
+	}
+	for i := 0; i < n; i++ {
+		var ack string
+		if err := caller.CallContext(ctx, "Push.Deliver", "msg"+strconv.Itoa(i), &ack); err != nil {
+			return err
+		}
+		if ack != "ack msg"+strconv.Itoa(i) {
+			return errors.New("unexpected ack " + ack)
+		}
+		*delivered++
+	}
+	return nil
+}
+
+// Push 注册在客户端上，处理函数中可以再调用回服务端
+type Push struct {
+	mu  sync.Mutex
+	got []string
+}
+
+func (p *Push) Deliver(ctx context.Context, msg string, ack *string) error {
+	p.mu.Lock()
+	p.got = append(p.got, msg)
+	p.mu.Unlock()
+	var sum int
+	if err := CallerFromContext(ctx).CallContext(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 1}, &sum); err != nil || sum != 2 {
+		return errors.New("nested call failed")
+	}
+	*ack = "ack " + msg
+	return nil
+}
+
+func TestReverseCall(t *testing.T) {
+	server, addr := startTestServer(t)
+	_assert(server.Register(Hub{}) == nil, "register Hub failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	push := &Push{}
+	_assert(client.Register(push) == nil, "register Push failed")
+
+	// 多个订阅并发进行，两个方向的 Seq 交错也不会串
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var delivered int
+			err := client.Call("Hub.Subscribe", 10, &delivered)
+			_assert(err == nil && delivered == 10, "subscribe failed: %v %d", err, delivered)
+		}()
+	}
+	wg.Wait()
+	push.mu.Lock()
+	defer push.mu.Unlock()
+	_assert(len(push.got) == 50, "expect 50 deliveries, got %d", len(push.got))
+}
+
+func TestReverseCall_Unregistered(t *testing.T) {
+	server, addr := startTestServer(t)
+	_assert(server.Register(Hub{}) == nil, "register Hub failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var delivered int
+	err = client.Call("Hub.Subscribe", 1, &delivered)
+	_assert(err != nil && delivered == 0, "expect error when the client has no Push receiver")
+}
+
+// Slow 注册在客户端上，阻塞到连接断开
+type Slow struct {
+	started  chan struct{}
+	finished atomic.Bool
+}
+
+func (s *Slow) Wait(ctx context.Context, n int, reply *int) error {
+	s.started <- struct{}{}
+	<-ctx.Done()
+	time.Sleep(20 * time.Millisecond)
+	s.finished.Store(true)
+	return ctx.Err()
+}
+
+// Nudge 反向调用客户端的 Slow.Wait
+type Nudge struct{}
+
+func (Nudge) Run(ctx context.Context, n int, reply *int) error {
+	return CallerFromContext(ctx).CallContext(ctx, "Slow.Wait", n, reply)
+}
+
+func TestReverseCall_HandlersDoNotOutliveClient(t *testing.T) {
+	server, addr := startTestServer(t)
+	_assert(server.Register(Nudge{}) == nil, "register Nudge failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	slow := &Slow{started: make(chan struct{}, 1)}
+	_assert(client.Register(slow) == nil, "register Slow failed")
+
+	call := client.Go("Nudge.Run", 1, new(int), nil)
+	<-slow.started
+	_ = client.Close()
+	<-client.received
+	_assert(slow.finished.Load(), "receive returned before the reverse call handler")
+	_assert((<-call.Done).Error != nil, "expect the call to fail after Close")
+}
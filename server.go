@@ -2,17 +2,20 @@ package FancyRPC
 
 import (
 	"FancyRPC/codec"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"go/ast"
 	"io"
-	"log"
 	"net"
+	"net/http"
 	"reflect"
+	"strconv"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"time"
 )
 
 //body 的格式和长度通过 header 中的 Content-Type 和 Content-Length 指定
@@ -26,6 +29,23 @@ const MagicNumber = 0x3befc
 type Option struct {
 	MagicNumber int
 	CodecType   string
+	// Compression 是 body 的压缩算法，取值为 codec.RegisterCompressor 注册过的名字，为空表示不压缩。
+	// 小于 CompressThreshold 字节的 body 不压缩，为 0 时使用 DefaultCompressThreshold。两端使用相同的设置。
+	Compression       string
+	CompressThreshold int
+	// StreamWindow 是流式调用每个方向上允许在途的消息数，接收方每消费一半窗口就归还额度。为 0 时使用 DefaultStreamWindow。
+	StreamWindow int
+	Logger       Logger   `json:"-"` // Logger 仅在客户端本地生效，不参与协商；为 nil 时静默
+	Metrics      *Metrics `json:"-"` // Metrics 不为 nil 时记录客户端按目标地址区分的指标
+	Tracer       *Tracer  `json:"-"` // Tracer 不为 nil 时为每次调用开启 client span 并传播 traceparent
+	// HeartbeatInterval 大于 0 时客户端按此间隔发送心跳，HeartbeatTimeout 内没有收到回复就关闭连接，
+	// 等待中的调用以 ErrHeartbeatTimeout 失败。HeartbeatTimeout 为 0 时等于 HeartbeatInterval。
+	HeartbeatInterval time.Duration `json:"-"`
+	HeartbeatTimeout  time.Duration `json:"-"`
+	// MaxHeaderSize 和 MaxBodySize 限制客户端收到的 header 和 body 的字节数，为 0 时使用默认值，小于 0 时不限制。
+	// body 超限的调用以 ResourceExhausted 错误失败，header 超限时关闭连接。
+	MaxHeaderSize int `json:"-"`
+	MaxBodySize   int `json:"-"`
 }
 
 var DefaultOption = &Option{
@@ -33,6 +53,36 @@ var DefaultOption = &Option{
 	CodecType:   codec.GobType,
 }
 
+const DefaultCompressThreshold = 1024
+
+const DefaultStreamWindow = 64
+
+// codecFunc 按 Option 协商的结果返回 Codec 的构造函数，需要压缩时再包一层 CompressCodec
+func codecFunc(opt *Option) (codec.NewCodecFunc, error) {
+	f := codec.NewCodecFuncMap[opt.CodecType]
+	if f == nil {
+		return nil, fmt.Errorf("invalid codec type %s", opt.CodecType)
+	}
+	if opt.Compression == "" {
+		return f, nil
+	}
+	c := codec.GetCompressor(opt.Compression)
+	if c == nil {
+		return nil, fmt.Errorf("invalid compression %s", opt.Compression)
+	}
+	m := codec.BodyMarshalerMap[opt.CodecType]
+	if m == nil {
+		return nil, fmt.Errorf("codec type %s does not support compression", opt.CodecType)
+	}
+	threshold := opt.CompressThreshold
+	if threshold == 0 {
+		threshold = DefaultCompressThreshold
+	}
+	return func(conn io.ReadWriteCloser) codec.Codec {
+		return codec.NewCompressCodec(f(conn), m, c, threshold)
+	}, nil
+}
+
 //涉及协议协商的这部分信息，需要设计固定的字节来传输的
 //客户端固定采用 JSON 编码 Option，后续的 header 和 body 的编码方式由 Option 中的 CodeType 指定，
 //服务端首先使用 JSON 解码 Option，然后通过 Option 的 CodeType 解码剩余的内容。即报文将以这样的形式发送
@@ -42,10 +92,90 @@ var DefaultOption = &Option{
 // 以下为server
 type Server struct {
 	serviceMap sync.Map
+	logger     Logger
+	metrics    *serverMetrics
+	tracer     *Tracer
+
+	heartbeatInterval time.Duration
+	heartbeatTimeout  time.Duration
+	idleTimeout       time.Duration
+
+	limitConfig
+	limits *concurrencyLimits // 未配置并发限制时为 nil
+
+	authenticator Authenticator
+	rateLimiter   *RateLimiter
+
+	maxHeaderSize int
+	maxBodySize   int
+
+	checkOrigin func(r *http.Request) bool // WebSocket 升级请求的 Origin 检查，nil 时只允许同源
+
+	reflection bool // 是否注册反射服务
 }
 
-func NewServer() *Server {
-	return &Server{}
+// ServerOption 用于配置 Server，例如 WithLogger
+type ServerOption func(*Server)
+
+// WithLogger 设置 Server 的日志输出，默认静默
+func WithLogger(l Logger) ServerOption {
+	return func(server *Server) {
+		if l != nil {
+			server.logger = l
+		}
+	}
+}
+
+// WithMetrics 把 Server 的指标记录到 m 中，m 可通过 ServeHTTP 暴露给 Prometheus
+func WithMetrics(m *Metrics) ServerOption {
+	return func(server *Server) {
+		if m != nil {
+			server.metrics = newServerMetrics(m)
+		}
+	}
+}
+
+// WithTracer 为每个请求开启 server span，父 span 取自请求头中的 traceparent
+func WithTracer(t *Tracer) ServerOption {
+	return func(server *Server) {
+		server.tracer = t
+	}
+}
+
+// WithHeartbeat 每个连接按 interval 向客户端发送心跳，timeout 内没有收到回复就关闭连接，timeout 为 0 时等于 interval
+func WithHeartbeat(interval, timeout time.Duration) ServerOption {
+	return func(server *Server) {
+		server.heartbeatInterval = interval
+		server.heartbeatTimeout = timeout
+	}
+}
+
+// WithIdleTimeout 连接上没有进行中的请求、且超过 d 没有收到请求时关闭连接，心跳不算在内
+func WithIdleTimeout(d time.Duration) ServerOption {
+	return func(server *Server) {
+		server.idleTimeout = d
+	}
+}
+
+// WithMaxMessageSize 限制收到的 header 和 body 的字节数，为 0 时使用默认值，小于 0 时不限制。
+// body 超限的请求以 ResourceExhausted 错误回复，header 超限或者超限后无法继续解析时关闭连接。
+func WithMaxMessageSize(header, body int) ServerOption {
+	return func(server *Server) {
+		server.maxHeaderSize = header
+		server.maxBodySize = body
+	}
+}
+
+func NewServer(opts ...ServerOption) *Server {
+	server := &Server{logger: NopLogger}
+	for _, opt := range opts {
+		opt(server)
+	}
+	server.limits = newConcurrencyLimits(server.limitConfig, server.metrics)
+	if server.reflection {
+		_ = server.Register(&Reflection{server: server})
+	}
+	return server
 }
 
 var DefaultServer = NewServer()
@@ -60,12 +190,15 @@ func (server *Server) Accept(lis net.Listener) {
 	// for each incoming connection.
 	for {
 		conn, err := lis.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			// Listener 被关闭，正常退出
+			server.logger.Log(LevelInfo, "rpc server: listener closed", F("addr", lis.Addr()))
+			return
+		}
 		if err != nil {
-			log.Println("rpc server accept error", err)
+			server.logger.Log(LevelError, "rpc server: accept error", F(FieldError, err))
 			return
 		}
-
-		log.Println("server accept ok 2")
 		go server.ServerConn(conn)
 	}
 }
@@ -79,152 +212,433 @@ func Accept(lis net.Listener) {
 // ServeConn blocks, serving the connection until the client hangs up. 服务会阻塞直到客户端挂起
 func (server *Server) ServerConn(conn io.ReadWriteCloser) {
 	defer func() { _ = conn.Close() }()
+	lg := server.logger
+	addr := remoteAddr(conn)
+	if addr != "" {
+		lg = lg.With(F(FieldRemoteAddr, addr))
+	}
+	if sm := server.metrics; sm != nil {
+		sm.activeConns.Inc()
+		defer sm.activeConns.Dec()
+		conn = &countingConn{ReadWriteCloser: conn, read: sm.readBytes, written: sm.writeBytes}
+	}
 	var opt Option
-	if err := json.NewDecoder(conn).Decode(&opt); err != nil {
-		log.Println("rpc server :option error ", err)
+	dec := json.NewDecoder(conn)
+	if err := dec.Decode(&opt); err != nil {
+		lg.Log(LevelError, "rpc server: option error", F(FieldError, err))
 		return
 	}
 
 	if opt.MagicNumber != MagicNumber {
-		log.Println("rpc server :MagicNumber invalid ")
+		lg.Log(LevelError, "rpc server: invalid magic number", F("magic_number", opt.MagicNumber))
 		return
 
 	}
-	f := codec.NewCodecFuncMap[opt.CodecType]
-	log.Printf("(server *Server) ServerConn() f %v ", reflect.TypeOf(f))
-	log.Printf("(server *Server) ServerConn() f %v ", opt.CodecType)
-
-	if f == nil {
-		log.Println("rpc server :CodecType invalid ")
+	f, err := codecFunc(&opt)
+	if err != nil {
+		lg.Log(LevelError, "rpc server: codec error", F(FieldError, err))
+		return
+	}
+	// json.Decoder 会预读，Option 之后已经被读进缓冲区的字节要还给 codec，
+	// 同时跳过 json.Encoder 在 Option 末尾写入的换行符
+	r := io.MultiReader(dec.Buffered(), conn)
+	var nl [1]byte
+	if _, err := io.ReadFull(r, nl[:]); err != nil || nl[0] != '\n' {
+		lg.Log(LevelError, "rpc server: option not terminated by newline", F(FieldError, err))
 		return
 	}
-	log.Printf("(server *Server) ServerConn(")
-	server.ServerCodec(f(conn))
+	window := opt.StreamWindow
+	if window <= 0 {
+		window = DefaultStreamWindow
+	}
+	server.serveCodec(f(&bufferedConn{Reader: r, ReadWriteCloser: conn}), lg, window, addr)
+}
+
+// bufferedConn 读取时先消费 json.Decoder 预读的字节，写入和关闭直接作用于原连接
+type bufferedConn struct {
+	io.Reader
+	io.ReadWriteCloser
+}
+
+func (c *bufferedConn) Read(p []byte) (int, error) { return c.Reader.Read(p) }
+
+// remoteAddr 在 conn 是 net.Conn 时返回对端地址
+func remoteAddr(conn io.ReadWriteCloser) string {
+	if c, ok := conn.(interface{ RemoteAddr() net.Addr }); ok && c.RemoteAddr() != nil {
+		return c.RemoteAddr().String()
+	}
+	return ""
 }
 
 var invalidRequest = struct {
 }{}
 
 func (server *Server) ServerCodec(cc codec.Codec) {
+	server.serveCodec(cc, server.logger, DefaultStreamWindow, "")
+}
+
+// serverConn 单个连接上的状态，读循环、各个处理协程和流共享。
+// Client 处理反向调用时也使用它，此时 cc 和 sending 与 Client 共用。
+type serverConn struct {
+	cc      codec.Codec
+	sending *sync.Mutex //处理请求是并发的，但是回复请求的报文必须是逐个发送的，并发容易导致多个回复报文交织在一起，客户端无法解析。在这里使用锁(sending)保证
+	lg      Logger
+	ctx     context.Context // 连接关闭时取消，携带 CallerFromContext 返回的 Client
+	cancel  context.CancelFunc
+	window  int // 流控窗口，见 Option.StreamWindow
+
+	remoteAddr string // 对端地址，连接不是 net.Conn 时为空
+
+	lastActive atomic.Int64 // 最近一次收到请求帧或请求处理完的时间，UnixNano
+	inFlight   atomic.Int32 // 进行中的请求数
+
+	mu      sync.Mutex
+	streams map[uint64]*serverStream // 进行中的流式调用
+}
+
+func newServerConn(cc codec.Codec, sending *sync.Mutex, lg Logger, window int, caller *Client) *serverConn {
+	ctx, cancel := context.WithCancel(context.WithValue(context.Background(), callerContextKey{}, caller))
+	sc := &serverConn{
+		cc:      cc,
+		sending: sending,
+		lg:      lg,
+		ctx:     ctx,
+		cancel:  cancel,
+		window:  window,
+		streams: make(map[uint64]*serverStream),
+	}
+	sc.touch()
+	return sc
+}
+
+type callerContextKey struct{}
+
+// CallerFromContext 返回发起本次调用的连接对应的 Client，处理函数可以用它反向调用对端注册的方法。
+// 服务端上得到的 Client 不需要也不应该 Close，连接断开后它上面的调用返回 ErrShutdown。
+// ctx 必须是处理函数收到的 context（方法的第一个参数为 context.Context 时传入），否则返回 nil。
+func CallerFromContext(ctx context.Context) *Client {
+	caller, _ := ctx.Value(callerContextKey{}).(*Client)
+	return caller
+}
+
+func (server *Server) serveCodec(cc codec.Codec, lg Logger, window int, remote string) {
 	sending := new(sync.Mutex)
-	//处理请求是并发的，但是回复请求的报文必须是逐个发送的，并发容易导致多个回复报文交织在一起，客户端无法解析。在这里使用锁(sending)保证
+	caller := newCaller(cc, &Option{StreamWindow: window, Tracer: server.tracer}, lg, sending, true)
+	sc := newServerConn(cc, sending, lg, window, caller)
+	sc.remoteAddr = remote
+	limitSizes(cc, server.maxHeaderSize, server.maxBodySize)
+	hb := startHeartbeat(cc, sending, lg, server.heartbeatInterval, server.heartbeatTimeout)
+	if server.idleTimeout > 0 {
+		go sc.reapIdle(server.idleTimeout)
+	}
 	wg := &sync.WaitGroup{} //返回wait对象的指针
 	for {
-		req, err := server.readRequest(cc)
+		h, err := server.readRequestHeader(cc, lg) //返回请求头的指针
 		if err != nil {
-			if req == nil {
+			break
+		}
+		handled, err := handleKeepalive(cc, sending, hb, h)
+		if handled {
+			if err != nil {
 				break
 			}
-			req.h.Error = err.Error()
-			server.sendResponse(cc, req.h, invalidRequest, sending)
 			continue
 		}
-		wg.Add(1) //一个请求可以包含多个req
-		go server.handleRequest(cc, req, sending, wg)
+		sc.touch()
+		if h.Reverse {
+			// 服务端发起的反向调用的响应
+			err = caller.dispatch(h)
+		} else {
+			err = server.serveFrame(sc, h, wg)
+		}
+		if err != nil {
+			break
+		}
 	}
+	hb.close()
+	sc.cancel()
+	sc.closeStreams()
+	caller.terminateCalls(hb.err(ErrShutdown))
 	wg.Wait()
 	_ = cc.Close()
 }
 
+// serveFrame 处理对端发来的一个请求帧或流上的后续帧，返回错误时连接无法继续使用
+func (server *Server) serveFrame(sc *serverConn, h *codec.Header, wg *sync.WaitGroup) error {
+	if h.Type != codec.FrameCall && h.Type != codec.FrameNotify {
+		// 已有流上的后续帧
+		if err := sc.handleFrame(h); err != nil {
+			sc.lg.Log(LevelError, "rpc server: read stream frame error",
+				F(FieldSeq, h.Seq), F("frame", h.Type), F(FieldError, err))
+			return err
+		}
+		return nil
+	}
+	ctx, err := server.admitRequest(sc, h)
+	if err != nil {
+		// 认证或限流失败的请求不读取参数，直接丢弃 body
+		if err := sc.cc.ReadBody(nil); err != nil && !bodySkipped(err) {
+			return err
+		}
+		if server.metrics != nil {
+			if err == ErrQuotaExceeded {
+				server.metrics.rateLimited.with(h.ServiceMethod).Inc()
+			} else {
+				server.metrics.invalid.Inc()
+			}
+		}
+		sc.lg.Log(LevelWarn, "rpc server: request rejected",
+			F(FieldSeq, h.Seq), F(FieldServiceMethod, h.ServiceMethod), F(FieldError, err))
+		if h.Type != codec.FrameNotify {
+			h.Metadata = nil
+			setHeaderError(h, err, Unauthenticated)
+			server.sendResponse(sc, h, invalidRequest)
+		}
+		return nil
+	}
+	req, err := server.readRequest(sc, h, ctx)
+	if err != nil {
+		if server.metrics != nil {
+			server.metrics.invalid.Inc()
+		}
+		// 单向调用从不回复，错误已经在 readRequest 中记录
+		if h.Type != codec.FrameNotify {
+			setHeaderError(req.h, err, InvalidArgument)
+			server.sendResponse(sc, req.h, invalidRequest)
+		}
+		if errors.Is(err, codec.ErrStreamCorrupted) {
+			// 回复之后关闭连接
+			return err
+		}
+		return nil
+	}
+	// 超过并发限制时在读循环中直接拒绝，排队的请求在处理协程中等待，协程数因此有上限
+	acquire, err := server.limits.admit(req.h.ServiceMethod)
+	if err != nil {
+		server.reject(sc, req, err)
+		return nil
+	}
+	wg.Add(1) //一个请求可以包含多个req
+	sc.inFlight.Add(1)
+	go server.handleRequest(sc, req, wg, acquire)
+	return nil
+}
+
 type request struct {
 	h            *codec.Header
 	argv, replyv reflect.Value //本来就是反射类型
 	mtype        *methodType
 	svc          *service
+	ctx          context.Context // 连接关闭时取消，流式调用被客户端取消时也会取消
+	stream       *serverStream   // 流式调用时不为 nil
 }
 
 // 协议解析
-func (server *Server) readRequestHeader(cc codec.Codec) (*codec.Header, error) {
+func (server *Server) readRequestHeader(cc codec.Codec, lg Logger) (*codec.Header, error) {
 	var h codec.Header
 
 	if err := cc.ReadHeader(&h); err != nil {
 		if err != io.EOF && err != io.ErrUnexpectedEOF {
-			log.Println("rpc server :read header ok")
+			lg.Log(LevelError, "rpc server: read header error", F(FieldError, err))
 		}
-		log.Printf("readRequestHeader err:%s\n", err)
 		return nil, err
 	}
 	//如解析成功则返回h的指针，其指针类型是*codec.Header
 	return &h, nil
 }
 
-func (server *Server) readRequest(cc codec.Codec) (*request, error) {
-	log.Printf("111111111111111111111")
-	log.Printf("(server *Server) readReques in readRequest%v", cc)
-	h, err := server.readRequestHeader(cc) //返回请求头的指针
-	if err != nil {
-		return nil, err
-	}
-	req := &request{h: h} //结构体指针中 有请求头指针,
+func (server *Server) readRequest(sc *serverConn, h *codec.Header, ctx context.Context) (*request, error) {
+	var err error
+	req := &request{h: h, ctx: ctx} //结构体指针中 有请求头指针,
 
-	log.Printf("find service info  in readRequest%s:\n", h.ServiceMethod)
 	req.svc, req.mtype, err = server.findService(h.ServiceMethod)
 	if err != nil {
-		log.Printf("find service error in readRequest%s:\n", err)
+		sc.lg.Log(LevelWarn, "rpc server: find service error",
+			F(FieldSeq, h.Seq), F(FieldServiceMethod, h.ServiceMethod), F(FieldError, err))
+		// body 仍然要读掉，否则会影响下一个请求的解析
+		_ = sc.cc.ReadBody(nil)
+		return req, err
+	}
+
+	if h.Type == codec.FrameNotify && (req.mtype.clientStream || req.mtype.serverStream) {
+		err = Errorf(InvalidArgument, "rpc server: cannot notify a streaming method %s", h.ServiceMethod)
+		sc.lg.Log(LevelWarn, "rpc server: invalid notification",
+			F(FieldSeq, h.Seq), F(FieldServiceMethod, h.ServiceMethod), F(FieldError, err))
+		_ = sc.cc.ReadBody(nil)
 		return req, err
 	}
 
 	req.argv = req.mtype.newArgv()
 	req.replyv = req.mtype.newReplyv()
 
+	if req.mtype.clientStream || req.mtype.serverStream {
+		// 流要在读循环继续之前注册好，后续帧才能找到它
+		req.stream = sc.openStream(ctx, h, req.mtype)
+		req.ctx = req.stream.ctx
+		if req.mtype.serverStream {
+			req.replyv.Interface().(serverStreamer).bindSend(req.stream)
+		}
+		if req.mtype.clientStream {
+			req.argv.Interface().(recvStreamer).bindRecv(req.stream)
+			// 客户端流的第一帧只用来打开流，body 为空
+			if err = sc.cc.ReadBody(nil); err != nil {
+				sc.closeStream(req.stream)
+				return req, readArgvError(err)
+			}
+			return req, nil
+		}
+	}
+
 	//确保传入的是指针
 	argvi := req.argv.Interface()
 	if req.argv.Type().Kind() != reflect.Ptr {
 		argvi = req.argv.Addr().Interface()
 	}
 
-	req.argv = reflect.New(reflect.TypeOf(""))
 	// 这里为什么传interface,可以储存不同类型的值，泛型字段
-	if err = cc.ReadBody(argvi); err != nil {
-		log.Println("rpc server: read argv err:", err)
-
+	if err = sc.cc.ReadBody(argvi); err != nil {
+		sc.lg.Log(LevelWarn, "rpc server: read argv error",
+			F(FieldSeq, h.Seq), F(FieldServiceMethod, h.ServiceMethod), F(FieldError, err))
+		if req.stream != nil {
+			sc.closeStream(req.stream)
+		}
+		return req, readArgvError(err)
 	}
 	return req, nil
 }
 
-func (server *Server) sendResponse(cc codec.Codec, h *codec.Header, body interface{}, sending *sync.Mutex) {
-	//log.Println("加 sendResponse ending.Lock() 锁")
-	// 发送之前对
-	sending.Lock()
-	defer sending.Unlock()
-	if err := cc.Write(h, body); err != nil {
-		log.Println("rpc server:write response error", err)
+// readArgvError 参数超过大小限制时错误码为 ResourceExhausted，其余为 InvalidArgument。
+// 保留原始错误，读循环据此判断连接能否继续使用。
+func readArgvError(err error) error {
+	if errors.Is(err, codec.ErrMessageTooLarge) {
+		return messageTooLarge("rpc server: read argv", err)
 	}
-	//sending.Unlock()
+	return &Error{Code: InvalidArgument, Message: "rpc server: read argv: " + err.Error(), cause: err}
 }
 
-func (server *Server) handleRequest(cc codec.Codec, req *request, sending *sync.Mutex, wg *sync.WaitGroup) {
+func (server *Server) sendResponse(sc *serverConn, h *codec.Header, body interface{}) {
+	err := sc.write(h, body)
+	if err != nil {
+		sc.lg.Log(LevelError, "rpc server: write response error",
+			F(FieldSeq, h.Seq), F(FieldServiceMethod, h.ServiceMethod), F(FieldError, err))
+	}
+	// 回复无法编码时连接上没有写出任何字节，改为回复错误，否则客户端会一直等待
+	if errors.Is(err, codec.ErrMarshalBody) && h.Error == "" {
+		setHeaderError(h, Errorf(Internal, "rpc server: can't encode reply: %v", err), Internal)
+		if err := sc.write(h, invalidRequest); err != nil {
+			sc.lg.Log(LevelError, "rpc server: write response error",
+				F(FieldSeq, h.Seq), F(FieldServiceMethod, h.ServiceMethod), F(FieldError, err))
+		}
+	}
+}
 
-	defer wg.Done()
-	//log.Println(*req.h, req.argv.Elem())
-	//req.replyv = reflect.ValueOf(1)
-	//目前只处理返回序列
+// write 发送之前加锁，保证报文逐个写出。metadata 只随请求发送，响应中去掉
+func (sc *serverConn) write(h *codec.Header, body interface{}) error {
+	h.Metadata = nil
+	sc.sending.Lock()
+	defer sc.sending.Unlock()
+	return sc.cc.Write(h, body)
+}
 
-	//调用目标函数
-	err := req.svc.call(req.mtype, req.argv, req.replyv)
+func (server *Server) handleRequest(sc *serverConn, req *request, wg *sync.WaitGroup, acquire acquireFunc) {
+
+	defer wg.Done()
+	defer func() {
+		sc.touch()
+		sc.inFlight.Add(-1)
+	}()
+	release, err := acquire(req.ctx)
 	if err != nil {
-		req.h.Error = err.Error()
-		server.sendResponse(cc, req.h, invalidRequest, sending)
+		// 排队超时
+		server.reject(sc, req, err)
 		return
 	}
-	req.replyv = reflect.ValueOf(fmt.Sprintf("FancyRpc resp %d", req.h.Seq))
-	//req.replyv = fmt.Sprintf("FancyRpc resp %d", req.h.Seq)
-	// req.replyv.Interface() 泛型，可以是任何类型
-	//server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+	finish := server.metrics.begin(req.h.ServiceMethod)
+	span := server.startSpan(req.h)
+	ctx := req.ctx
+	if span != nil {
+		// 处理函数中的反向调用延续同一个 trace
+		ctx = ContextWithSpan(ctx, span)
+	}
+	//调用目标函数
+	err = req.svc.call(ctx, req.mtype, req.argv, req.replyv)
+	release()
+	finish(err)
+	if span != nil {
+		span.End(err)
+	}
+	if err != nil && req.h.Type == codec.FrameNotify {
+		// 单向调用没有人等待结果，错误只能记录在日志和指标中
+		sc.lg.Log(LevelWarn, "rpc server: notification handler returned error",
+			F(FieldServiceMethod, req.h.ServiceMethod), F(FieldError, err))
+	}
+	server.finishRequest(sc, req, err)
+}
+
+// reject 请求因为并发限制没有执行，按正常的错误返回给调用方
+func (server *Server) reject(sc *serverConn, req *request, err error) {
+	if server.metrics != nil {
+		server.metrics.rejected.with(req.h.ServiceMethod).Inc()
+	}
+	sc.lg.Log(LevelWarn, "rpc server: request rejected",
+		F(FieldSeq, req.h.Seq), F(FieldServiceMethod, req.h.ServiceMethod), F(FieldError, err))
+	server.finishRequest(sc, req, err)
+}
 
+// finishRequest 把结果发回调用方，单向调用什么也不发
+func (server *Server) finishRequest(sc *serverConn, req *request, err error) {
+	if req.h.Type == codec.FrameNotify {
+		return
+	}
+	if req.stream != nil {
+		// 流式方法的结果由结束帧带回，客户端流方法的返回值作为结束帧之前的最后一条消息
+		var reply interface{}
+		if err == nil && !req.mtype.serverStream {
+			reply = req.replyv.Interface()
+		}
+		if werr := req.stream.end(reply, err); werr != nil {
+			sc.lg.Log(LevelError, "rpc server: write stream end error",
+				F(FieldSeq, req.h.Seq), F(FieldServiceMethod, req.h.ServiceMethod), F(FieldError, werr))
+		}
+		return
+	}
+	if err != nil {
+		if sc.lg.Enabled(LevelDebug) {
+			sc.lg.Log(LevelDebug, "rpc server: handler returned error",
+				F(FieldSeq, req.h.Seq), F(FieldServiceMethod, req.h.ServiceMethod), F(FieldError, err))
+		}
+		setHeaderError(req.h, err, Unknown)
+		server.sendResponse(sc, req.h, invalidRequest)
+		return
+	}
 	// req.replyv.Interface() 泛型，可以是任何类型
-	server.sendResponse(cc, req.h, req.replyv.Interface(), sending)
+	server.sendResponse(sc, req.h, req.replyv.Interface())
+}
 
+// startSpan 在配置了 Tracer 时开启 server span，请求头中带有合法 traceparent 时延续调用方的 trace
+func (server *Server) startSpan(h *codec.Header) *Span {
+	if server.tracer == nil {
+		return nil
+	}
+	parent, _ := ParseTraceParent(h.TraceParent)
+	span := server.tracer.startSpan(parent, h.ServiceMethod, SpanKindServer)
+	span.SetAttribute("rpc.system", "fancyrpc")
+	span.SetAttribute("rpc.method", h.ServiceMethod)
+	span.SetAttribute("rpc.seq", strconv.FormatUint(h.Seq, 10))
+	return span
 }
 
 // 在给定的代码中，req.replyv是一个reflect.Value类型的对象，通过调用Interface()方法，可以将其底层值转换为interface{}类型。
 // 这样做的意义在于，可以将不同类型的值传递给sendResponse方法，而不需要显式地指定具体的类型。这种灵活性使得代码可以处理各种类型的响应值，而不需要为每种类型编写不同的处理逻辑。
 // 总结来说，使用reflect.ValueOf和Interface()可以在运行时动态地处理不同类型的值，提供了更大的灵活性和通用性。这对于需要处理未知类型或根据条件进行不同操作的情况非常有用
 type methodType struct {
-	method    reflect.Method
-	ArgType   reflect.Type
-	ReplyType reflect.Type
-	numCalls  uint64
+	method       reflect.Method
+	ArgType      reflect.Type
+	ReplyType    reflect.Type
+	numCalls     uint64
+	serverStream bool // ReplyType 是 *ServerStream[R]
+	clientStream bool // ArgType 是 *RecvStream[A]
+	withContext  bool // 第一个参数是 context.Context
 }
 
 func (m *methodType) NumCalls() uint64 {
@@ -236,21 +650,22 @@ func (m *methodType) newArgv() reflect.Value {
 	var argv reflect.Value
 	//指针类型和值类型创建实例的方式有细微区别
 	//该方法用于根据 methodType 结构体中的 ArgType 字段的类型信息 创建一个新的参数对象。
-	//if m.ArgType.Kind() == reflect.Ptr {
-	//	// 如果是指针类型则创建一个
-	//	//新的指针类型对象赋值给argv
-	//	argv = reflect.New(m.ArgType.Elem())
-	//
-	//} else {
-	// 如果非指针则返回对象
-	argv = reflect.New(m.ArgType).Elem()
-	//}
+	if m.ArgType.Kind() == reflect.Ptr {
+		// 如果是指针类型则创建一个新的指针类型对象赋值给argv，例如 protobuf 消息
+		argv = reflect.New(m.ArgType.Elem())
+	} else {
+		// 如果非指针则返回对象
+		argv = reflect.New(m.ArgType).Elem()
+	}
 	return argv
 
 }
 
 func (m *methodType) newReplyv() reflect.Value {
 	replyv := reflect.New(m.ReplyType.Elem())
+	if m.serverStream {
+		return replyv
+	}
 	switch m.ReplyType.Elem().Kind() {
 	case reflect.Map:
 		replyv.Elem().Set(reflect.MakeMap(m.ReplyType.Elem()))
@@ -262,6 +677,7 @@ func (m *methodType) newReplyv() reflect.Value {
 
 }
 
+// service RegisterFunc 注册的服务没有 typ 和 rcvr
 type service struct {
 	name   string                 //name 即映射的结构体的名称，比如 T，比如 WaitGroup
 	typ    reflect.Type           ///typ 是结构体的类型
@@ -270,40 +686,90 @@ type service struct {
 }
 
 func (server *Server) Register(rcvr interface{}) error {
-	s := newService(rcvr)
+	s, err := newService(rcvr)
+	if err != nil {
+		return err
+	}
 
 	// LoadOrStore 存在则加载dup为true，不存在侧存储dup为false
 	if _, dup := server.serviceMap.LoadOrStore(s.name, s); dup {
-		return errors.New("rpc: service already defined" + s.name)
-	} else {
-		log.Println("service register ok" + s.name)
+		return errors.New("rpc: service already defined: " + s.name)
 	}
+	server.logger.Log(LevelInfo, "rpc server: service registered", F("service", s.name))
 	return nil
 }
 func Register(rcvr interface{}) error { return DefaultServer.Register(rcvr) }
 
+// RegisterFunc 把函数 fn 注册为 serviceMethod，fn 的签名与方法去掉接收者后相同，例如
+// func(A, *R) error 或 func(context.Context, A, *R) error，规则与 Register 相同。
+// 同一个服务名下可以注册多个函数，但不能与 Register 注册的服务重名。
+func (server *Server) RegisterFunc(serviceMethod string, fn interface{}) error {
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 || !ast.IsExported(serviceMethod[:dot]) || !ast.IsExported(serviceMethod[dot+1:]) {
+		return errors.New("rpc: " + serviceMethod + " is not a valid Service.Method name")
+	}
+	name, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
+	fv := reflect.ValueOf(fn)
+	if fv.Kind() != reflect.Func {
+		return fmt.Errorf("rpc: %s must be a function, got %T", serviceMethod, fn)
+	}
+	if fv.IsNil() {
+		return fmt.Errorf("rpc: %s must not be a nil function", serviceMethod)
+	}
+	// 与 Register 不同，reply 必须是指针，否则函数写入的回复无法返回给调用方
+	mtype := newMethodType(fv.Type(), 0)
+	if mtype == nil || mtype.ReplyType.Kind() != reflect.Ptr {
+		return fmt.Errorf("rpc: %s has an invalid signature %s", serviceMethod, fv.Type())
+	}
+	mtype.method = reflect.Method{Name: methodName, Type: fv.Type(), Func: fv}
+
+	// 正在处理的请求可能在读 service.method，因此复制一份再替换
+	for {
+		s := &service{name: name, method: map[string]*methodType{methodName: mtype}}
+		old, loaded := server.serviceMap.LoadOrStore(name, s)
+		if !loaded {
+			break
+		}
+		prev := old.(*service)
+		if prev.rcvr.IsValid() {
+			return errors.New("rpc: service already defined: " + name)
+		}
+		if prev.method[methodName] != nil {
+			return errors.New("rpc: method already defined: " + serviceMethod)
+		}
+		for k, m := range prev.method {
+			s.method[k] = m
+		}
+		if server.serviceMap.CompareAndSwap(name, old, s) {
+			break
+		}
+	}
+	server.logger.Log(LevelInfo, "rpc server: function registered", F(FieldServiceMethod, serviceMethod))
+	return nil
+}
+func RegisterFunc(serviceMethod string, fn interface{}) error {
+	return DefaultServer.RegisterFunc(serviceMethod, fn)
+}
+
 func (server *Server) findService(serviceMethod string) (svc *service, mtype *methodType, err error) {
 	//为 ServiceMethod 的构成是 “Service.Method”，因此先将其分割成 2 部分，第一部分是 Service 的名称，
 	//第二部分即方法名。现在 serviceMap 中找到对应的 service 实例，再从 service 实例的 method 中，找到对应的 methodType。
-	serviceMethod = "Foo.Sum"
 	dot := strings.LastIndex(serviceMethod, ".")
-
-	log.Println(" (server *Server) findService serviceMethod!!!!!", serviceMethod)
 	if dot < 0 {
-		err = errors.New("rpc server: service/method request ill-formed: " + serviceMethod)
+		err = Errorf(InvalidArgument, "rpc server: service/method request ill-formed: %s", serviceMethod)
 		return
 	}
 
 	serverName, methodName := serviceMethod[:dot], serviceMethod[dot+1:]
 	svci, ok := server.serviceMap.Load(serverName)
 	if !ok {
-		err = errors.New("rpc server:cant find service: " + serviceMethod)
+		err = Errorf(NotFound, "rpc server:cant find service: %s", serviceMethod)
 		return
 	}
 	svc = svci.(*service)
 	mtype = svc.method[methodName]
 	if mtype == nil {
-		err = errors.New("rpc server: can't find method " + methodName)
+		err = Errorf(NotFound, "rpc server: can't find method %s", methodName)
 	}
 	return
 
@@ -313,30 +779,52 @@ func (s *service) registMethod() {
 	s.method = make(map[string]*methodType)
 	for i := 0; i < s.typ.NumMethod(); i++ {
 		method := s.typ.Method(i)
-		mType := method.Type
-		if mType.NumIn() != 3 || mType.NumOut() != 1 {
-			continue
+		if mtype := newMethodType(method.Type, 1); mtype != nil {
+			mtype.method = method
+			s.method[method.Name] = mtype
 		}
-		if mType.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
-			continue
-		}
-		argType, replyType := mType.In(1), mType.In(2)
-		if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
-			continue
-		}
-		s.method[method.Name] = &methodType{
-			method:    method,
-			ArgType:   argType,
-			ReplyType: replyType,
-		}
-		log.Printf("rpc server: register %s.%s\n", s.name, method.Name)
 	}
 }
+
+// newMethodType 检查函数类型 ft 是否可以作为 RPC 方法，skip 是参数中接收者的个数，不符合规则时返回 nil
+func newMethodType(ft reflect.Type, skip int) *methodType {
+	// 方法可以额外带一个 context.Context 作为第一个参数：func (t *T) M(ctx context.Context, args A, reply *R) error
+	withContext := ft.NumIn() == skip+3 && ft.In(skip) == contextType
+	if (ft.NumIn() != skip+2 && !withContext) || ft.NumOut() != 1 {
+		return nil
+	}
+	if ft.Out(0) != reflect.TypeOf((*error)(nil)).Elem() {
+		return nil
+	}
+	argType, replyType := ft.In(ft.NumIn()-2), ft.In(ft.NumIn()-1)
+	if !isExportedOrBuiltinType(argType) || !isExportedOrBuiltinType(replyType) {
+		return nil
+	}
+	// 第一个参数是 *RecvStream[A] 时为客户端流，第二个参数是 *ServerStream[R] 时为服务端流，两者都是时为双向流
+	isServerStream := replyType.Implements(serverStreamerType)
+	if isServerStream && !isExportedOrBuiltinType(reflect.Zero(replyType).Interface().(serverStreamer).elemType()) {
+		return nil
+	}
+	isClientStream := argType.Implements(recvStreamerType)
+	if isClientStream && !isExportedOrBuiltinType(reflect.Zero(argType).Interface().(recvStreamer).elemType()) {
+		return nil
+	}
+	return &methodType{
+		ArgType:      argType,
+		ReplyType:    replyType,
+		serverStream: isServerStream,
+		clientStream: isClientStream,
+		withContext:  withContext,
+	}
+}
+
+var contextType = reflect.TypeOf((*context.Context)(nil)).Elem()
+
 func isExportedOrBuiltinType(t reflect.Type) bool {
 	return ast.IsExported(t.Name()) || t.PkgPath() == ""
 }
 
-func newService(rcvr interface{}) *service {
+func newService(rcvr interface{}) (*service, error) {
 	///client 需要首先调用newService 来获取Service 可用的method
 	s := &service{}
 	//回参结构rcvr,处理到service 结构体中，做一个service实例
@@ -345,17 +833,25 @@ func newService(rcvr interface{}) *service {
 	s.typ = reflect.TypeOf(rcvr)
 
 	if !ast.IsExported(s.name) {
-		log.Fatal(fmt.Sprintf("rpc server: %s is not a valid service name", s.name))
+		return nil, fmt.Errorf("rpc server: %s is not a valid service name", s.name)
 	}
 	s.registMethod() //注册该服务下所有方法
-	return s
+	return s, nil
 }
 
-// 即能够通过反射值调用方法
-func (s *service) call(m *methodType, argv, replyv reflect.Value) error {
+// 即能够通过反射值调用方法，ctx 只在方法声明了 context.Context 参数时传入
+func (s *service) call(ctx context.Context, m *methodType, argv, replyv reflect.Value) error {
 	atomic.AddUint64(&m.numCalls, 1)
 	f := m.method.Func
-	returnValues := f.Call([]reflect.Value{s.rcvr, argv, replyv})
+	in := []reflect.Value{s.rcvr, argv, replyv}
+	if m.withContext {
+		in = []reflect.Value{s.rcvr, reflect.ValueOf(ctx), argv, replyv}
+	}
+	if !s.rcvr.IsValid() {
+		// RegisterFunc 注册的函数没有接收者
+		in = in[1:]
+	}
+	returnValues := f.Call(in)
 	if errInter := returnValues[0].Interface(); errInter != nil {
 		return errInter.(error)
 	}
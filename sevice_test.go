@@ -1,8 +1,10 @@
 package FancyRPC
 
 import (
+	"context"
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -29,21 +31,32 @@ func _assert(condition bool, msg string, v ...interface{}) {
 
 // 测试用例
 func TestNewService(t *testing.T) {
-	var foo Foo           //这个是回参的接收
-	s := newService(&foo) //返回取得的服务指针，并且将该服务注册 ， 入参是interface泛型
+	var foo Foo                //这个是回参的接收
+	s, err := newService(&foo) //返回取得的服务指针，并且将该服务注册 ， 入参是interface泛型
+	_assert(err == nil, "newService failed: %v", err)
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"] //取得远程调用函数？？
 	_assert(mType != nil, "wrong Method, Sum shouldn't nil")
 
 }
+
+// 服务名不合法时返回错误，而不是退出进程
+func TestRegister_InvalidName(t *testing.T) {
+	type foo struct{ Foo }
+	server := NewServer()
+	err := server.Register(&foo{})
+	_assert(err != nil && strings.Contains(err.Error(), "foo is not a valid service name"), "expect invalid name error, got %v", err)
+	_, ok := server.serviceMap.Load("foo")
+	_assert(!ok, "invalid service should not be registered")
+}
 func TestMethodType_Call(t *testing.T) {
-	var foo Foo           //这个是回参的接收
-	s := newService(&foo) //返回取得的服务指针，并且将该服务注册 ， 入参是interface泛型
+	var foo Foo              //这个是回参的接收
+	s, _ := newService(&foo) //返回取得的服务指针，并且将该服务注册 ， 入参是interface泛型
 	_assert(len(s.method) == 1, "wrong service Method, expect 1, but got %d", len(s.method))
 	mType := s.method["Sum"] //取得远程调用函数？？
 	argv := mType.newArgv()
 	replyv := mType.newReplyv()
 	argv.Set(reflect.ValueOf(Args{Num1: 1, Num2: 3}))
-	err := s.call(mType, argv, replyv)
+	err := s.call(context.Background(), mType, argv, replyv)
 	_assert(err == nil && *replyv.Interface().(*int) == 4 && mType.NumCalls() == 1, "failed to call Foo.Sum")
 }
@@ -0,0 +1,41 @@
+package FancyRPC
+
+import (
+	"FancyRPC/codec"
+	"errors"
+)
+
+// 消息大小限制：两端各自限制收到的 header 和 body，在解码之前按帧检查，
+// 对端声明或发送的字节数超过限制时不会为它分配内存。
+// 超限的 body 能整帧跳过时连接继续可用，请求以 ResourceExhausted 回复，响应以 ResourceExhausted 失败；
+// header 超限、或者超限后数据无法继续解析（例如 msgpack）时关闭连接。
+
+const (
+	DefaultMaxHeaderSize = 1 << 20
+	DefaultMaxBodySize   = 4 << 20
+)
+
+// limitSizes 为 0 时使用默认值，小于 0 时不限制；Codec 没有实现 codec.SizeLimiter 时不生效
+func limitSizes(cc codec.Codec, maxHeader, maxBody int) {
+	l, ok := cc.(codec.SizeLimiter)
+	if !ok {
+		return
+	}
+	if maxHeader == 0 {
+		maxHeader = DefaultMaxHeaderSize
+	}
+	if maxBody == 0 {
+		maxBody = DefaultMaxBodySize
+	}
+	l.SetMaxSizes(maxHeader, maxBody)
+}
+
+// bodySkipped 超过大小限制的 body 已经整帧丢弃，连接仍然可用
+func bodySkipped(err error) bool {
+	return errors.Is(err, codec.ErrMessageTooLarge) && !errors.Is(err, codec.ErrStreamCorrupted)
+}
+
+// messageTooLarge 给超限的错误加上 ResourceExhausted 错误码，原始错误仍然可以用 errors.Is 判断
+func messageTooLarge(msg string, err error) error {
+	return &Error{Code: ResourceExhausted, Message: msg + ": " + err.Error(), cause: err}
+}
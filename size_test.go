@@ -0,0 +1,85 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"testing"
+
+	"FancyRPC/codec"
+)
+
+func startSizeServer(t *testing.T, opts ...ServerOption) string {
+	server := NewServer(opts...)
+	_assert(server.Register(Blob{}) == nil, "register Blob failed")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	return l.Addr().String()
+}
+
+func TestMaxMessageSize_Request(t *testing.T) {
+	addr := startSizeServer(t, WithMaxMessageSize(0, 1024))
+	large := RepeatArgs{S: strings.Repeat("x", 4096), N: 1}
+	small := RepeatArgs{S: "fancy", N: 2}
+
+	for _, ct := range []string{codec.GobType, codec.MsgpackType} {
+		client, err := Dial("tcp", addr, &Option{CodecType: ct})
+		_assert(err == nil, "%s: dial failed: %v", ct, err)
+
+		var reply string
+		err = client.Call("Blob.Repeat", large, &reply)
+		_assert(CodeOf(err) == ResourceExhausted, "%s: expect ResourceExhausted, got %v", ct, err)
+
+		err = client.Call("Blob.Repeat", small, &reply)
+		if ct == codec.GobType {
+			// gob 按长度前缀跳过超限的消息，连接仍然可用
+			_assert(err == nil && reply == "fancyfancy", "%s: unexpected reply %q %v", ct, reply, err)
+		} else {
+			// msgpack 无法跳过，回复错误后连接被关闭
+			_assert(err != nil, "%s: expect connection to be closed", ct)
+		}
+		_ = client.Close()
+	}
+}
+
+func TestMaxMessageSize_Response(t *testing.T) {
+	addr := startSizeServer(t)
+	client, err := Dial("tcp", addr, &Option{MaxBodySize: 1024})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	var reply string
+	err = client.Call("Blob.Repeat", RepeatArgs{S: "fancy", N: 1000}, &reply)
+	_assert(CodeOf(err) == ResourceExhausted && errors.Is(err, codec.ErrMessageTooLarge),
+		"expect ResourceExhausted, got %v", err)
+
+	err = client.Call("Blob.Repeat", RepeatArgs{S: "fancy", N: 2}, &reply)
+	_assert(err == nil && reply == "fancyfancy", "unexpected reply %q %v", reply, err)
+}
+
+func TestMaxMessageSize_Decompressed(t *testing.T) {
+	// 压缩后很小的 body 解压后同样受限制
+	addr := startSizeServer(t, WithMaxMessageSize(0, 1024))
+	for _, compression := range []string{codec.GzipCompression, codec.SnappyCompression} {
+		client, err := Dial("tcp", addr, &Option{Compression: compression})
+		_assert(err == nil, "dial failed: %v", err)
+
+		var reply string
+		err = client.Call("Blob.Repeat", RepeatArgs{S: strings.Repeat("x", 100000), N: 1}, &reply)
+		_assert(CodeOf(err) == ResourceExhausted, "%s: expect ResourceExhausted, got %v", compression, err)
+		_ = client.Close()
+	}
+}
+
+func TestMaxMessageSize_Header(t *testing.T) {
+	addr := startSizeServer(t, WithMaxMessageSize(256, 0))
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.ProtobufType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx := ContextWithMetadata(context.Background(), Metadata{"token": strings.Repeat("t", 1024)})
+	err = client.CallContext(ctx, "Blob.Repeat", nil, nil)
+	_assert(CodeOf(err) == Unavailable, "expect connection closed, got %v", err)
+}
@@ -0,0 +1,167 @@
+package FancyRPC
+
+import (
+	"FancyRPC/codec"
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// 错误码：服务端把处理函数返回的错误转换成 Code + Message + Details 放进响应头，
+// 客户端再还原成 *Error，调用方可以用 CodeOf 或 errors.Is/As 区分错误的种类。
+// 取值与 gRPC 的状态码一致，方便与其他系统对照。
+
+type Code uint32
+
+const (
+	OK                 Code = 0  // 成功，不会出现在错误中
+	Canceled           Code = 1  // 调用被调用方取消
+	Unknown            Code = 2  // 处理函数返回了没有错误码的普通错误
+	InvalidArgument    Code = 3  // 参数无法解析或不合法
+	DeadlineExceeded   Code = 4  // 超时
+	NotFound           Code = 5  // 服务、方法或处理函数要找的资源不存在
+	AlreadyExists      Code = 6  // 要创建的资源已经存在
+	PermissionDenied   Code = 7  // 调用方没有权限
+	ResourceExhausted  Code = 8  // 超过并发限制或限流配额
+	FailedPrecondition Code = 9  // 系统状态不允许执行该操作
+	Aborted            Code = 10 // 操作因为冲突被中止
+	OutOfRange         Code = 11 // 参数超出有效范围
+	Unimplemented      Code = 12 // 方法不支持该调用方式
+	Internal           Code = 13 // 服务端内部错误
+	Unavailable        Code = 14 // 连接断开或服务暂时不可用，通常可以重试
+	DataLoss           Code = 15 // 数据丢失或损坏
+	Unauthenticated    Code = 16 // 调用方没有通过认证
+)
+
+var codeNames = [...]string{
+	"OK", "Canceled", "Unknown", "InvalidArgument", "DeadlineExceeded", "NotFound", "AlreadyExists",
+	"PermissionDenied", "ResourceExhausted", "FailedPrecondition", "Aborted", "OutOfRange",
+	"Unimplemented", "Internal", "Unavailable", "DataLoss", "Unauthenticated",
+}
+
+func (c Code) String() string {
+	if int(c) < len(codeNames) {
+		return codeNames[c]
+	}
+	return "Code(" + strconv.FormatUint(uint64(c), 10) + ")"
+}
+
+// Error 带错误码的错误，处理函数返回它时错误码和 Details 原样传给客户端。
+// Error() 只返回 Message，与没有错误码时的错误文本保持一致。
+type Error struct {
+	Code    Code
+	Message string
+	Details map[string]string // 可选的附加信息，例如出错的字段名
+
+	cause error // 本地产生的错误的原始错误
+}
+
+func (e *Error) Error() string { return e.Message }
+
+func (e *Error) Unwrap() error { return e.cause }
+
+// Is 错误码相同、且 target 的 Message 为空或相同时认为匹配，
+// 因此 errors.Is(err, ErrServerBusy) 对从服务端还原出的错误同样成立。
+// DeadlineExceeded 和 Canceled 还分别与 context.DeadlineExceeded、context.Canceled 匹配。
+func (e *Error) Is(target error) bool {
+	switch target {
+	case context.DeadlineExceeded:
+		return e.Code == DeadlineExceeded
+	case context.Canceled:
+		return e.Code == Canceled
+	}
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code && (t.Message == "" || t.Message == e.Message)
+}
+
+// WithDetail 返回附加了一项 detail 的副本
+func (e *Error) WithDetail(key, value string) *Error {
+	cp := *e
+	cp.Details = make(map[string]string, len(e.Details)+1)
+	for k, v := range e.Details {
+		cp.Details[k] = v
+	}
+	cp.Details[key] = value
+	return &cp
+}
+
+// NewError 返回带错误码的错误
+func NewError(code Code, msg string) *Error {
+	return &Error{Code: code, Message: msg}
+}
+
+// Errorf 与 fmt.Errorf 相同，但带上错误码
+func Errorf(code Code, format string, a ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, a...)}
+}
+
+// CodeOf 返回 err 的错误码：nil 为 OK，链上有 *Error 时取它的错误码，context 的错误按对应的错误码，其余为 Unknown
+func CodeOf(err error) Code {
+	return statusOf(err).Code
+}
+
+func statusOf(err error) *Error {
+	if err == nil {
+		return &Error{Code: OK}
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		if e.Message == err.Error() {
+			return e
+		}
+		// 被包装过时使用完整的错误文本
+		return &Error{Code: e.Code, Message: err.Error(), Details: e.Details}
+	}
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return &Error{Code: DeadlineExceeded, Message: err.Error()}
+	case errors.Is(err, context.Canceled):
+		return &Error{Code: Canceled, Message: err.Error()}
+	}
+	return &Error{Code: Unknown, Message: err.Error()}
+}
+
+// statusWithDefault 与 statusOf 相同，但没有错误码的普通错误使用 code
+func statusWithDefault(err error, code Code) *Error {
+	st := statusOf(err)
+	if st.Code == Unknown {
+		var e *Error
+		if !errors.As(err, &e) {
+			st.Code = code
+		}
+	}
+	return st
+}
+
+// unavailable 给连接层面的错误加上 Unavailable 错误码，原始错误仍然可以用 errors.Is 判断
+func unavailable(err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return &Error{Code: Unavailable, Message: err.Error(), cause: err}
+}
+
+// setHeaderError 把 err 写入响应头，没有错误码的错误默认使用 code
+func setHeaderError(h *codec.Header, err error, code Code) {
+	st := statusWithDefault(err, code)
+	h.Error = st.Message
+	if h.Error == "" {
+		h.Error = st.Code.String()
+	}
+	h.Code = uint32(st.Code)
+	h.ErrorDetails = st.Details
+}
+
+// errorFromHeader 还原响应头中的错误，没有错误时返回 nil；对端没有发送错误码时为 Unknown
+func errorFromHeader(h *codec.Header) error {
+	if h.Error == "" && h.Code == 0 {
+		return nil
+	}
+	e := &Error{Code: Code(h.Code), Message: h.Error, Details: h.ErrorDetails}
+	if e.Code == OK {
+		e.Code = Unknown
+	}
+	return e
+}
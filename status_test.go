@@ -0,0 +1,65 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+type Users struct{}
+
+func (Users) Get(id int, name *string) error {
+	switch id {
+	case 1:
+		*name = "alice"
+		return nil
+	case 2:
+		return Errorf(NotFound, "user %d not found", id).WithDetail("id", "2")
+	case 3:
+		return fmt.Errorf("lookup user %d: %w", id, context.DeadlineExceeded)
+	default:
+		return errors.New("database is on fire")
+	}
+}
+
+func TestStatus_EndToEnd(t *testing.T) {
+	server, addr := startTestServer(t)
+	_assert(server.Register(Users{}) == nil, "register Users failed")
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+
+	var name string
+	_assert(client.Call("Users.Get", 1, &name) == nil && name == "alice", "Users.Get failed")
+
+	err = client.Call("Users.Get", 2, &name)
+	var e *Error
+	_assert(errors.As(err, &e) && e.Code == NotFound && e.Message == "user 2 not found" && e.Details["id"] == "2",
+		"unexpected coded error %#v", err)
+	_assert(errors.Is(err, &Error{Code: NotFound}), "errors.Is should match by code")
+
+	err = client.Call("Users.Get", 3, &name)
+	_assert(CodeOf(err) == DeadlineExceeded && errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+
+	err = client.Call("Users.Get", 4, &name)
+	_assert(CodeOf(err) == Unknown && err.Error() == "database is on fire", "plain errors keep their text, got %v", err)
+
+	err = client.Call("Users.Missing", 1, &name)
+	_assert(CodeOf(err) == NotFound, "expect NotFound for unknown method, got %v (%s)", err, CodeOf(err))
+
+	err = client.Call("Users.Get", "not an int", &name)
+	_assert(CodeOf(err) == InvalidArgument, "expect InvalidArgument for undecodable args, got %v (%s)", err, CodeOf(err))
+
+	_ = client.Close()
+	err = client.Call("Users.Get", 1, &name)
+	_assert(errors.Is(err, ErrShutdown) && CodeOf(err) == Unavailable, "expect Unavailable after close, got %v", err)
+}
+
+func TestStatus_Is(t *testing.T) {
+	remote := &Error{Code: ResourceExhausted, Message: "rpc server: server busy"}
+	_assert(errors.Is(remote, ErrServerBusy) && !errors.Is(remote, ErrQuotaExceeded), "sentinels should match by code and message")
+	_assert(CodeOf(nil) == OK && CodeOf(context.Canceled) == Canceled, "CodeOf of local errors")
+	wrapped := fmt.Errorf("call: %w", Errorf(PermissionDenied, "nope"))
+	_assert(CodeOf(wrapped) == PermissionDenied, "CodeOf should look through wrapping")
+	_assert(Code(99).String() == "Code(99)" && Unauthenticated.String() == "Unauthenticated", "Code.String")
+}
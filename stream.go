@@ -0,0 +1,502 @@
+package FancyRPC
+
+import (
+	"FancyRPC/codec"
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"sync"
+)
+
+// 流式调用。处理函数的两个参数决定了方法的类型：
+//
+//	func (t *T) List(args Args, stream *ServerStream[Row]) error        // 服务端流
+//	func (t *T) Upload(stream *RecvStream[Chunk], reply *Result) error  // 客户端流
+//	func (t *T) Chat(in *RecvStream[Msg], out *ServerStream[Msg]) error // 双向流
+//
+// 同一个流的所有帧使用同一个 Seq：客户端先发送 FrameCall 打开流（服务端流带参数，其余为空 body），
+// 之后双方用 FrameData 发送消息，客户端用 FrameCloseSend 表示发送完毕、FrameCancel 取消，
+// 服务端处理函数返回后发送 FrameEnd，返回的 error 放在结束帧的 Error 中；
+// 客户端流方法的 reply 作为结束帧之前的最后一条 FrameData。
+//
+// 流控以消息数为单位：每个方向初始有 Option.StreamWindow 条额度，发送一条消耗一条，
+// 额度用完时 Send 阻塞；接收方每消费半个窗口就用 FrameWindow 归还额度，
+// 因此任何一端缓存的消息都不会超过一个窗口。
+
+// ErrStreamClosed 流已经结束或被取消之后再发送
+var ErrStreamClosed = errors.New("rpc: stream closed")
+
+var errFlowControl = errors.New("rpc: peer exceeded stream window")
+
+// sendWindow 发送方向的额度
+type sendWindow struct {
+	mu     sync.Mutex
+	credit int
+	closed bool
+	notify chan struct{}
+}
+
+func newSendWindow(credit int) *sendWindow {
+	return &sendWindow{credit: credit, notify: make(chan struct{}, 1)}
+}
+
+// acquire 取得一条消息的额度，没有额度时等待对端归还
+func (w *sendWindow) acquire(ctx context.Context) error {
+	for {
+		w.mu.Lock()
+		if w.closed {
+			w.mu.Unlock()
+			return ErrStreamClosed
+		}
+		if w.credit > 0 {
+			w.credit--
+			w.mu.Unlock()
+			return nil
+		}
+		w.mu.Unlock()
+		select {
+		case <-w.notify:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (w *sendWindow) add(n int) {
+	w.mu.Lock()
+	w.credit += n
+	w.mu.Unlock()
+	wake(w.notify)
+}
+
+func (w *sendWindow) close() {
+	w.mu.Lock()
+	w.closed = true
+	w.mu.Unlock()
+	wake(w.notify)
+}
+
+// recvQueue 接收方向的队列，接收协程放入、Recv 取出，每消费半个窗口调用一次 grant 归还额度
+type recvQueue struct {
+	mu       sync.Mutex
+	queue    []reflect.Value
+	notify   chan struct{}
+	closed   bool
+	err      error // 队列关闭的原因，nil 表示对端正常发送完毕
+	window   int
+	consumed int
+	grant    func(n int)
+}
+
+func newRecvQueue(window int, grant func(n int)) *recvQueue {
+	return &recvQueue{notify: make(chan struct{}, 1), window: window, grant: grant}
+}
+
+// push 由接收协程调用，不会阻塞；对端超出窗口时返回 false
+func (q *recvQueue) push(v reflect.Value) bool {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return true
+	}
+	// 客户端流的 reply 不占用额度，所以允许多出一条
+	if len(q.queue) > q.window {
+		q.mu.Unlock()
+		return false
+	}
+	q.queue = append(q.queue, v)
+	q.mu.Unlock()
+	wake(q.notify)
+	return true
+}
+
+// pop 取出下一条消息，队列关闭且为空时返回 io.EOF 或关闭的原因
+func (q *recvQueue) pop(ctx context.Context) (reflect.Value, error) {
+	for {
+		q.mu.Lock()
+		if len(q.queue) > 0 {
+			v := q.queue[0]
+			q.queue[0] = reflect.Value{}
+			q.queue = q.queue[1:]
+			var n int
+			if q.consumed++; q.consumed >= (q.window+1)/2 && !q.closed {
+				n, q.consumed = q.consumed, 0
+			}
+			q.mu.Unlock()
+			if n > 0 {
+				q.grant(n)
+			}
+			return v, nil
+		}
+		if q.closed {
+			err := q.err
+			q.mu.Unlock()
+			if err == nil {
+				err = io.EOF
+			}
+			return reflect.Value{}, err
+		}
+		q.mu.Unlock()
+		select {
+		case <-q.notify:
+		case <-ctx.Done():
+			return reflect.Value{}, ctx.Err()
+		}
+	}
+}
+
+// close 只生效一次，已经收到的消息仍然可以取出
+func (q *recvQueue) close(err error) {
+	q.mu.Lock()
+	if !q.closed {
+		q.closed = true
+		q.err = err
+	}
+	q.mu.Unlock()
+	wake(q.notify)
+}
+
+func wake(c chan struct{}) {
+	select {
+	case c <- struct{}{}:
+	default:
+	}
+}
+
+// ServerStream 服务端向客户端发送消息
+type ServerStream[R any] struct {
+	core *serverStream
+}
+
+// Send 发送一条消息，额度用完时阻塞；流被取消、连接断开或处理函数已返回时返回错误
+func (s *ServerStream[R]) Send(msg R) error {
+	return s.core.send(msg)
+}
+
+// Context 在客户端取消流或连接关闭时被取消，长时间运行的处理函数应当据此提前退出
+func (s *ServerStream[R]) Context() context.Context {
+	return s.core.ctx
+}
+
+func (s *ServerStream[R]) bindSend(core *serverStream) { s.core = core }
+
+func (s *ServerStream[R]) elemType() reflect.Type { return reflect.TypeOf((*R)(nil)).Elem() }
+
+// RecvStream 服务端接收客户端发送的消息
+type RecvStream[A any] struct {
+	core *serverStream
+}
+
+// Recv 返回下一条消息，客户端 CloseSend 之后返回 io.EOF，流被取消时返回 context 的错误
+func (s *RecvStream[A]) Recv() (A, error) {
+	var msg A
+	v, err := s.core.recv.pop(s.core.ctx)
+	if err != nil {
+		return msg, err
+	}
+	return v.Elem().Interface().(A), nil
+}
+
+func (s *RecvStream[A]) Context() context.Context {
+	return s.core.ctx
+}
+
+func (s *RecvStream[A]) bindRecv(core *serverStream) { s.core = core }
+
+func (s *RecvStream[A]) elemType() reflect.Type { return reflect.TypeOf((*A)(nil)).Elem() }
+
+// serverStreamer 由 *ServerStream[R] 实现，registMethod 据此识别服务端流
+type serverStreamer interface {
+	bindSend(core *serverStream)
+	elemType() reflect.Type
+}
+
+// recvStreamer 由 *RecvStream[A] 实现，registMethod 据此识别客户端流
+type recvStreamer interface {
+	bindRecv(core *serverStream)
+	elemType() reflect.Type
+}
+
+var (
+	serverStreamerType = reflect.TypeOf((*serverStreamer)(nil)).Elem()
+	recvStreamerType   = reflect.TypeOf((*recvStreamer)(nil)).Elem()
+)
+
+// serverStream 服务端一侧的流，与具体消息类型无关
+type serverStream struct {
+	sc       *serverConn
+	h        codec.Header // 打开流的请求头
+	ctx      context.Context
+	cancel   context.CancelFunc
+	closed   bool // 由 sc.sending 保护
+	credit   *sendWindow
+	recv     *recvQueue // 客户端流和双向流才有
+	recvType reflect.Type
+}
+
+// openStream 创建并登记一个流，parent 是请求的 context
+func (sc *serverConn) openStream(parent context.Context, h *codec.Header, mtype *methodType) *serverStream {
+	ctx, cancel := context.WithCancel(parent)
+	st := &serverStream{sc: sc, h: *h, ctx: ctx, cancel: cancel, credit: newSendWindow(sc.window)}
+	st.h.Error = ""
+	st.h.Metadata = nil
+	if mtype.clientStream {
+		st.recvType = reflect.Zero(mtype.ArgType).Interface().(recvStreamer).elemType()
+		st.recv = newRecvQueue(sc.window, func(n int) {
+			h := st.h
+			h.Type = codec.FrameWindow
+			h.Window = uint32(n)
+			if err := sc.write(&h, invalidRequest); err != nil {
+				sc.lg.Log(LevelError, "rpc server: write window error", F(FieldSeq, h.Seq), F(FieldError, err))
+			}
+		})
+	}
+	sc.mu.Lock()
+	sc.streams[h.Seq] = st
+	sc.mu.Unlock()
+	return st
+}
+
+// abort 取消处理函数的 context，唤醒阻塞中的 Send 和 Recv
+func (st *serverStream) abort(err error) {
+	st.cancel()
+	st.credit.close()
+	if st.recv != nil {
+		st.recv.close(err)
+	}
+}
+
+// closeStream 注销流并取消它
+func (sc *serverConn) closeStream(st *serverStream) {
+	sc.mu.Lock()
+	if sc.streams[st.h.Seq] == st {
+		delete(sc.streams, st.h.Seq)
+	}
+	sc.mu.Unlock()
+	st.abort(ErrStreamClosed)
+}
+
+// closeStreams 连接结束时取消所有的流
+func (sc *serverConn) closeStreams() {
+	sc.mu.Lock()
+	streams := sc.streams
+	sc.streams = make(map[uint64]*serverStream)
+	sc.mu.Unlock()
+	for _, st := range streams {
+		st.abort(ErrShutdown)
+	}
+}
+
+// handleFrame 处理已有流上的后续帧，返回的错误表示连接上的数据已经无法继续解析
+func (sc *serverConn) handleFrame(h *codec.Header) error {
+	sc.mu.Lock()
+	st := sc.streams[h.Seq]
+	sc.mu.Unlock()
+
+	if h.Type == codec.FrameData {
+		if st == nil || st.recv == nil {
+			return sc.cc.ReadBody(nil)
+		}
+		v := reflect.New(st.recvType)
+		if err := sc.cc.ReadBody(v.Interface()); err != nil {
+			if bodySkipped(err) {
+				st.abort(messageTooLarge("rpc server: read stream message", err))
+				return nil
+			}
+			return err
+		}
+		if !st.recv.push(v) {
+			sc.lg.Log(LevelWarn, "rpc server: client exceeded stream window",
+				F(FieldSeq, h.Seq), F(FieldServiceMethod, st.h.ServiceMethod))
+			st.abort(errFlowControl)
+		}
+		return nil
+	}
+	if err := sc.cc.ReadBody(nil); err != nil {
+		return err
+	}
+	if st == nil {
+		// 流已经结束，迟到的控制帧直接忽略
+		return nil
+	}
+	switch h.Type {
+	case codec.FrameCloseSend:
+		if st.recv != nil {
+			st.recv.close(nil)
+		}
+	case codec.FrameCancel:
+		st.abort(context.Canceled)
+	case codec.FrameWindow:
+		st.credit.add(int(h.Window))
+	}
+	return nil
+}
+
+func (st *serverStream) send(msg interface{}) error {
+	if err := st.credit.acquire(st.ctx); err != nil {
+		return err
+	}
+	st.sc.sending.Lock()
+	defer st.sc.sending.Unlock()
+	if st.closed {
+		return ErrStreamClosed
+	}
+	h := st.h
+	h.Type = codec.FrameData
+	return st.sc.cc.Write(&h, msg)
+}
+
+// end 在处理函数返回后调用：注销流，reply 不为 nil 时先发送它，再发送结束帧
+func (st *serverStream) end(reply interface{}, err error) error {
+	st.sc.closeStream(st)
+	st.sc.sending.Lock()
+	defer st.sc.sending.Unlock()
+	st.closed = true
+	h := st.h
+	if reply != nil {
+		h.Type = codec.FrameData
+		if werr := st.sc.cc.Write(&h, reply); werr != nil {
+			return werr
+		}
+	}
+	h.Type = codec.FrameEnd
+	if err != nil {
+		setHeaderError(&h, err, Unknown)
+	}
+	return st.sc.cc.Write(&h, invalidRequest)
+}
+
+// ClientStream 客户端一侧的流
+type ClientStream struct {
+	call      *Call
+	ctx       context.Context
+	replyType reflect.Type
+	recv      *recvQueue
+	credit    *sendWindow
+
+	mu       sync.Mutex  // 保护 stop 和 finished，finish 可能与 openStream 并发
+	stop     func() bool // 停止监听 ctx
+	finished bool
+
+	sendClosed bool // 由 client.sending 保护
+}
+
+// Stream 调用服务端流式方法。reply 是指向消息类型的指针，只用来确定消息类型，例如 new(Row)。
+// ctx 被取消后流被取消，Recv 返回 ctx 的错误，服务端处理函数的 Context 也会被取消。
+func (client *Client) Stream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	return client.openStream(ctx, serviceMethod, args, reply)
+}
+
+// OpenStream 调用客户端流或双向流方法，之后用 Send 发送、CloseSend 结束发送、Recv 读取服务端的消息。
+// 客户端流方法的返回值通过 Recv 读取，reply 的含义与 Stream 相同。
+func (client *Client) OpenStream(ctx context.Context, serviceMethod string, reply interface{}) (*ClientStream, error) {
+	return client.openStream(ctx, serviceMethod, invalidRequest, reply)
+}
+
+func (client *Client) openStream(ctx context.Context, serviceMethod string, args, reply interface{}) (*ClientStream, error) {
+	rt := reflect.TypeOf(reply)
+	if rt == nil || rt.Kind() != reflect.Ptr {
+		return nil, errors.New("rpc client: stream reply must be a pointer")
+	}
+	call := client.newCall(ctx, serviceMethod, args, nil, make(chan *Call, 1))
+	stream := &ClientStream{
+		call:      call,
+		ctx:       ctx,
+		replyType: rt.Elem(),
+		credit:    newSendWindow(client.window),
+	}
+	stream.recv = newRecvQueue(client.window, func(n int) {
+		stream.writeFrame(codec.FrameWindow, uint32(n), invalidRequest)
+	})
+	call.stream = stream
+	client.send(call)
+	// 必须在 send 分配 Seq 之后注册，否则 cancel 会按错误的 Seq 移除调用；
+	// 服务端可能已经拒绝了这个流，此时 finish 已经执行过，直接停止监听
+	stop := context.AfterFunc(ctx, func() { stream.cancel(ctx.Err()) })
+	stream.mu.Lock()
+	finished := stream.finished
+	if !finished {
+		stream.stop = stop
+	}
+	stream.mu.Unlock()
+	if finished {
+		stop()
+	}
+	return stream, nil
+}
+
+// Recv 把下一条消息写入 reply，流正常结束时返回 io.EOF，服务端返回错误时返回该错误
+func (s *ClientStream) Recv(reply interface{}) error {
+	rv := reflect.ValueOf(reply)
+	if rv.Kind() != reflect.Ptr || rv.Elem().Type() != s.replyType {
+		return errors.New("rpc client: stream reply type mismatch")
+	}
+	v, err := s.recv.pop(s.ctx)
+	if err != nil {
+		return err
+	}
+	rv.Elem().Set(v.Elem())
+	return nil
+}
+
+// Send 发送一条消息，额度用完时阻塞，流结束或被取消后返回错误
+func (s *ClientStream) Send(msg interface{}) error {
+	if err := s.credit.acquire(s.ctx); err != nil {
+		return err
+	}
+	client := s.call.client
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if s.sendClosed {
+		return ErrStreamClosed
+	}
+	h := codec.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Type: codec.FrameData, Reverse: client.reverse}
+	return client.cc.Write(&h, msg)
+}
+
+// CloseSend 通知服务端不再发送消息
+func (s *ClientStream) CloseSend() error {
+	client := s.call.client
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	if s.sendClosed {
+		return nil
+	}
+	s.sendClosed = true
+	h := codec.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Type: codec.FrameCloseSend, Reverse: client.reverse}
+	return client.cc.Write(&h, invalidRequest)
+}
+
+func (s *ClientStream) writeFrame(typ codec.FrameType, window uint32, body interface{}) {
+	client := s.call.client
+	client.sending.Lock()
+	defer client.sending.Unlock()
+	h := codec.Header{ServiceMethod: s.call.ServiceMethod, Seq: s.call.Seq, Type: typ, Window: window, Reverse: client.reverse}
+	if err := client.cc.Write(&h, body); err != nil {
+		client.logger.Log(LevelError, "rpc client: write stream frame error",
+			F(FieldSeq, h.Seq), F("frame", typ), F(FieldError, err))
+	}
+}
+
+// cancel 在 ctx 被取消时调用：结束本地的调用并通知服务端
+func (s *ClientStream) cancel(err error) {
+	if call := s.call.client.removeCall(s.call.Seq); call != nil {
+		call.Error = err
+		call.done()
+		s.writeFrame(codec.FrameCancel, 0, invalidRequest)
+	}
+}
+
+// finish 在调用结束时由 Call.done 调用
+func (s *ClientStream) finish(err error) {
+	s.mu.Lock()
+	s.finished = true
+	stop := s.stop
+	s.mu.Unlock()
+	if stop != nil {
+		stop()
+	}
+	s.recv.close(err)
+	s.credit.close()
+}
@@ -0,0 +1,277 @@
+package FancyRPC
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+type Row struct {
+	I    int
+	Name string
+}
+
+type Rows struct{}
+
+// List 发送 n 行，n 为负数时发送一行后返回错误
+func (Rows) List(n int, stream *ServerStream[Row]) error {
+	if n < 0 {
+		_ = stream.Send(Row{I: 0})
+		return errors.New("negative count")
+	}
+	for i := 0; i < n; i++ {
+		if err := stream.Send(Row{I: i, Name: "row"}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Forever 一直发送直到连接关闭
+func (Rows) Forever(_ int, stream *ServerStream[Row]) error {
+	for i := 0; ; i++ {
+		select {
+		case <-stream.Context().Done():
+			return stream.Context().Err()
+		default:
+		}
+		if err := stream.Send(Row{I: i}); err != nil {
+			return err
+		}
+	}
+}
+
+// Upload 客户端流，返回收到的数字之和
+func (Rows) Upload(in *RecvStream[int], sum *int) error {
+	for {
+		n, err := in.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		*sum += n
+	}
+}
+
+// Echo 双向流，把收到的每一行原样发回
+func (Rows) Echo(in *RecvStream[Row], out *ServerStream[Row]) error {
+	for {
+		row, err := in.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = out.Send(row); err != nil {
+			return err
+		}
+	}
+}
+
+// Gate 在 release 关闭之前不读取消息，用来观察流控；返回时关闭 done
+type Gate struct {
+	release chan struct{}
+	done    chan error
+}
+
+func (g *Gate) Hold(in *RecvStream[int], n *int) error {
+	select {
+	case <-g.release:
+	case <-in.Context().Done():
+		g.done <- in.Context().Err()
+		return in.Context().Err()
+	}
+	for {
+		_, err := in.Recv()
+		if err == io.EOF {
+			g.done <- nil
+			return nil
+		}
+		if err != nil {
+			g.done <- err
+			return err
+		}
+		*n++
+	}
+}
+
+func startStreamServer(t *testing.T, opts ...*Option) *Client {
+	t.Helper()
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(Rows{}) == nil && server.Register(&foo) == nil, "register failed")
+	return dialStreamServer(t, server, opts...)
+}
+
+func dialStreamServer(t *testing.T, server *Server, opts ...*Option) *Client {
+	t.Helper()
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	t.Cleanup(func() { _ = l.Close() })
+	go server.Accept(l)
+	client, err := Dial("tcp", l.Addr().String(), opts...)
+	_assert(err == nil, "dial failed: %v", err)
+	t.Cleanup(func() { _ = client.Close() })
+	return client
+}
+
+func TestNewService_ServerStream(t *testing.T) {
+	s, _ := newService(Rows{})
+	mType := s.method["List"]
+	_assert(mType != nil && mType.serverStream && !mType.clientStream, "List should be registered as a server stream")
+	mType = s.method["Upload"]
+	_assert(mType != nil && mType.clientStream && !mType.serverStream, "Upload should be registered as a client stream")
+	mType = s.method["Echo"]
+	_assert(mType != nil && mType.clientStream && mType.serverStream, "Echo should be registered as a bidi stream")
+}
+
+func TestServerStream_Recv(t *testing.T) {
+	client := startStreamServer(t)
+	stream, err := client.Stream(context.Background(), "Rows.List", 1000, new(Row))
+	_assert(err == nil, "stream failed: %v", err)
+
+	// 流进行中普通调用不受影响
+	var sum int
+	_assert(client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &sum) == nil && sum == 3, "Foo.Sum failed")
+
+	n := 0
+	for {
+		var row Row
+		err := stream.Recv(&row)
+		if err == io.EOF {
+			break
+		}
+		_assert(err == nil && row.I == n && row.Name == "row", "unexpected row %+v %v", row, err)
+		n++
+	}
+	_assert(n == 1000, "expect 1000 rows, got %d", n)
+	_assert(stream.Recv(new(Row)) == io.EOF, "Recv after end should keep returning io.EOF")
+}
+
+func TestServerStream_Error(t *testing.T) {
+	client := startStreamServer(t)
+	stream, _ := client.Stream(context.Background(), "Rows.List", -1, new(Row))
+	var row Row
+	_assert(stream.Recv(&row) == nil, "expect the first row")
+	err := stream.Recv(&row)
+	_assert(err != nil && err != io.EOF && err.Error() == "negative count", "expect handler error, got %v", err)
+}
+
+func TestServerStream_Cancel(t *testing.T) {
+	client := startStreamServer(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	stream, _ := client.Stream(ctx, "Rows.Forever", 0, new(Row))
+	var err error
+	for err == nil {
+		err = stream.Recv(new(Row))
+	}
+	_assert(errors.Is(err, context.DeadlineExceeded), "expect deadline exceeded, got %v", err)
+
+	// 取消之后连接仍然可用
+	var sum int
+	_assert(client.Call("Foo.Sum", &Args{Num1: 2, Num2: 2}, &sum) == nil && sum == 4, "Foo.Sum failed")
+}
+
+// 服务端立即拒绝的流在 openStream 返回前就可能结束，用 -race 运行
+func TestClientStream_Rejected(t *testing.T) {
+	client := startStreamServer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	for i := 0; i < 200; i++ {
+		stream, err := client.Stream(ctx, "Rows.Nope", 0, new(Row))
+		_assert(err == nil, "stream failed: %v", err)
+		err = stream.Recv(new(Row))
+		_assert(err != nil && err != io.EOF, "expect an error for an unknown method, got %v", err)
+	}
+	_assert(client.pendingCalls() == 0, "rejected streams should not stay pending")
+}
+
+func TestClientStream_Upload(t *testing.T) {
+	client := startStreamServer(t, &Option{StreamWindow: 4})
+	stream, err := client.OpenStream(context.Background(), "Rows.Upload", new(int))
+	_assert(err == nil, "open stream failed: %v", err)
+	for i := 1; i <= 100; i++ {
+		_assert(stream.Send(i) == nil, "send failed")
+	}
+	_assert(stream.CloseSend() == nil, "close send failed")
+	_assert(stream.Send(1) == ErrStreamClosed, "Send after CloseSend should fail")
+	var sum int
+	_assert(stream.Recv(&sum) == nil && sum == 5050, "expect 5050, got %d", sum)
+	_assert(stream.Recv(&sum) == io.EOF, "expect io.EOF after the reply")
+}
+
+func TestBidiStream_Echo(t *testing.T) {
+	client := startStreamServer(t, &Option{StreamWindow: 2})
+	stream, _ := client.OpenStream(context.Background(), "Rows.Echo", new(Row))
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 200; i++ {
+			_ = stream.Send(Row{I: i, Name: "echo"})
+		}
+		_ = stream.CloseSend()
+	}()
+	n := 0
+	for {
+		var row Row
+		err := stream.Recv(&row)
+		if err == io.EOF {
+			break
+		}
+		_assert(err == nil && row.I == n && row.Name == "echo", "unexpected row %+v %v", row, err)
+		n++
+	}
+	<-done
+	_assert(n == 200, "expect 200 rows, got %d", n)
+}
+
+func TestClientStream_FlowControl(t *testing.T) {
+	server := NewServer()
+	gate := &Gate{release: make(chan struct{}), done: make(chan error, 1)}
+	_assert(server.Register(gate) == nil, "register failed")
+	client := dialStreamServer(t, server, &Option{StreamWindow: 4})
+	stream, _ := client.OpenStream(context.Background(), "Gate.Hold", new(int))
+
+	sent := make(chan int, 100)
+	go func() {
+		for i := 0; i < 20; i++ {
+			if stream.Send(i) != nil {
+				return
+			}
+			sent <- i
+		}
+		_ = stream.CloseSend()
+	}()
+	time.Sleep(100 * time.Millisecond)
+	_assert(len(sent) == 4, "Send should block after the window is used up, sent %d", len(sent))
+
+	close(gate.release)
+	var n int
+	_assert(stream.Recv(&n) == nil && n == 20, "expect 20 messages, got %d", n)
+	_assert(<-gate.done == nil, "handler failed")
+}
+
+func TestClientStream_CancelStopsHandler(t *testing.T) {
+	server := NewServer()
+	gate := &Gate{release: make(chan struct{}), done: make(chan error, 1)}
+	_assert(server.Register(gate) == nil, "register failed")
+	client := dialStreamServer(t, server)
+	ctx, cancel := context.WithCancel(context.Background())
+	stream, _ := client.OpenStream(ctx, "Gate.Hold", new(int))
+	_ = stream.Send(1)
+	cancel()
+	_assert(errors.Is(stream.Recv(new(int)), context.Canceled), "Recv should return the context error")
+	select {
+	case err := <-gate.done:
+		_assert(errors.Is(err, context.Canceled), "handler context should be canceled, got %v", err)
+	case <-time.After(time.Second):
+		t.Fatal("handler did not observe the cancellation")
+	}
+	_assert(stream.Send(2) != nil, "Send after cancel should fail")
+}
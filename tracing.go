@@ -0,0 +1,249 @@
+package FancyRPC
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// 分布式追踪：客户端在 send 中开启 client span，把 W3C traceparent 写入 codec.Header，
+// 服务端在 handleRequest 中据此开启 server span，结束后交给 SpanExporter 导出。
+// traceparent 格式见 https://www.w3.org/TR/trace-context/#traceparent-header
+
+type TraceID [16]byte
+
+type SpanID [8]byte
+
+func (t TraceID) String() string { return hex.EncodeToString(t[:]) }
+func (s SpanID) String() string  { return hex.EncodeToString(s[:]) }
+
+func (t TraceID) IsValid() bool { return t != TraceID{} }
+func (s SpanID) IsValid() bool  { return s != SpanID{} }
+
+// SpanContext 跨进程传播的部分
+type SpanContext struct {
+	TraceID TraceID
+	SpanID  SpanID
+	Sampled bool
+}
+
+func (sc SpanContext) IsValid() bool { return sc.TraceID.IsValid() && sc.SpanID.IsValid() }
+
+// TraceParent 按 version 00 编码为 traceparent
+func (sc SpanContext) TraceParent() string {
+	flags := "00"
+	if sc.Sampled {
+		flags = "01"
+	}
+	return "00-" + sc.TraceID.String() + "-" + sc.SpanID.String() + "-" + flags
+}
+
+var errInvalidTraceParent = errors.New("rpc tracing: invalid traceparent")
+
+// ParseTraceParent 解析 traceparent，未知的更高版本按规范只取前四段
+func ParseTraceParent(s string) (SpanContext, error) {
+	var sc SpanContext
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return sc, errInvalidTraceParent
+	}
+	version, err := hex.DecodeString(s[:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return sc, errInvalidTraceParent
+	}
+	if _, err = hex.Decode(sc.TraceID[:], []byte(s[3:35])); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	if _, err = hex.Decode(sc.SpanID[:], []byte(s[36:52])); err != nil {
+		return sc, errInvalidTraceParent
+	}
+	flags, err := hex.DecodeString(s[53:55])
+	if err != nil || !sc.IsValid() {
+		return sc, errInvalidTraceParent
+	}
+	sc.Sampled = flags[0]&0x01 == 0x01
+	return sc, nil
+}
+
+type SpanKind string
+
+const (
+	SpanKindClient SpanKind = "client"
+	SpanKindServer SpanKind = "server"
+)
+
+// SpanData 结束后的 span，交给 SpanExporter 导出
+type SpanData struct {
+	Name         string            `json:"name"`
+	Kind         SpanKind          `json:"kind"`
+	TraceID      string            `json:"trace_id"`
+	SpanID       string            `json:"span_id"`
+	ParentSpanID string            `json:"parent_span_id,omitempty"`
+	Start        time.Time         `json:"start"`
+	End          time.Time         `json:"end"`
+	Attributes   map[string]string `json:"attributes,omitempty"`
+	Error        string            `json:"error,omitempty"`
+}
+
+// Span 一个进行中的 span，End 只生效一次
+type Span struct {
+	tracer *Tracer
+	sc     SpanContext
+	data   SpanData
+	mu     sync.Mutex
+	ended  bool
+}
+
+func (s *Span) SpanContext() SpanContext { return s.sc }
+
+func (s *Span) SetAttribute(key, value string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.data.Attributes == nil {
+		s.data.Attributes = make(map[string]string)
+	}
+	s.data.Attributes[key] = value
+}
+
+// End 结束 span，err 不为 nil 时记录为错误
+func (s *Span) End(err error) {
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	s.data.End = time.Now()
+	if err != nil {
+		s.data.Error = err.Error()
+	}
+	data := s.data
+	s.mu.Unlock()
+	if s.sc.Sampled {
+		_ = s.tracer.exporter.ExportSpan(data)
+	}
+}
+
+// SpanExporter 可插拔的 span 导出接口
+type SpanExporter interface {
+	ExportSpan(span SpanData) error
+}
+
+// Tracer 创建 span 并导出，Server 通过 WithTracer、Client 通过 Option.Tracer 使用
+type Tracer struct {
+	exporter SpanExporter
+}
+
+func NewTracer(exporter SpanExporter) *Tracer {
+	return &Tracer{exporter: exporter}
+}
+
+type spanContextKey struct{}
+
+// ContextWithSpan 返回携带 span 的 context，之后在该 context 上开启的 span 以它为父
+func ContextWithSpan(ctx context.Context, span *Span) context.Context {
+	return context.WithValue(ctx, spanContextKey{}, span)
+}
+
+// SpanFromContext 取出 context 中当前的 span，没有则返回 nil
+func SpanFromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanContextKey{}).(*Span)
+	return span
+}
+
+// StartSpan 以 ctx 中的 span 为父开启新的 span
+func (t *Tracer) StartSpan(ctx context.Context, name string, kind SpanKind) (context.Context, *Span) {
+	var parent SpanContext
+	if p := SpanFromContext(ctx); p != nil {
+		parent = p.sc
+	}
+	span := t.startSpan(parent, name, kind)
+	return ContextWithSpan(ctx, span), span
+}
+
+// startSpan 以 parent 为父开启 span，parent 无效时开启新的 trace
+func (t *Tracer) startSpan(parent SpanContext, name string, kind SpanKind) *Span {
+	sc := SpanContext{Sampled: true}
+	if parent.IsValid() {
+		sc.TraceID = parent.TraceID
+		sc.Sampled = parent.Sampled
+	} else {
+		_, _ = rand.Read(sc.TraceID[:])
+	}
+	_, _ = rand.Read(sc.SpanID[:])
+	span := &Span{
+		tracer: t,
+		sc:     sc,
+		data: SpanData{
+			Name:    name,
+			Kind:    kind,
+			TraceID: sc.TraceID.String(),
+			SpanID:  sc.SpanID.String(),
+			Start:   time.Now(),
+		},
+	}
+	if parent.IsValid() {
+		span.data.ParentSpanID = parent.SpanID.String()
+	}
+	return span
+}
+
+// InMemoryExporter 把 span 保存在内存里，主要用于测试
+type InMemoryExporter struct {
+	mu    sync.Mutex
+	spans []SpanData
+}
+
+func NewInMemoryExporter() *InMemoryExporter { return &InMemoryExporter{} }
+
+func (e *InMemoryExporter) ExportSpan(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = append(e.spans, span)
+	return nil
+}
+
+// Spans 返回已导出 span 的副本
+func (e *InMemoryExporter) Spans() []SpanData {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return append([]SpanData(nil), e.spans...)
+}
+
+func (e *InMemoryExporter) Reset() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.spans = nil
+}
+
+// JSONFileExporter 每个 span 一行 JSON 追加写入文件，便于本地排查
+type JSONFileExporter struct {
+	mu  sync.Mutex
+	f   *os.File
+	enc *json.Encoder
+}
+
+func NewJSONFileExporter(path string) (*JSONFileExporter, error) {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("rpc tracing: open exporter file: %w", err)
+	}
+	return &JSONFileExporter{f: f, enc: json.NewEncoder(f)}, nil
+}
+
+func (e *JSONFileExporter) ExportSpan(span SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.enc.Encode(span)
+}
+
+func (e *JSONFileExporter) Close() error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.f.Close()
+}
@@ -0,0 +1,90 @@
+package FancyRPC
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestTracing_Propagation(t *testing.T) {
+	serverSpans, clientSpans := NewInMemoryExporter(), NewInMemoryExporter()
+	_, addr := startTestServer(t, WithTracer(NewTracer(serverSpans)))
+	tracer := NewTracer(clientSpans)
+	client, err := Dial("tcp", addr, &Option{Tracer: tracer})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+
+	ctx, root := tracer.StartSpan(context.Background(), "root", SpanKindClient)
+	var reply int
+	_assert(client.CallContext(ctx, "Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply) == nil, "failed to call Foo.Sum")
+	root.End(nil)
+
+	deadline := time.Now().Add(time.Second)
+	for len(serverSpans.Spans()) == 0 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	cs, ss := clientSpans.Spans(), serverSpans.Spans()
+	_assert(len(cs) == 2 && len(ss) == 1, "expect 2 client spans and 1 server span, got %d and %d", len(cs), len(ss))
+	call := cs[0]
+	_assert(call.Name == "Foo.Sum" && call.Kind == SpanKindClient, "unexpected client span %+v", call)
+	_assert(call.ParentSpanID == root.SpanContext().SpanID.String(), "client span should be child of root")
+	_assert(ss[0].Kind == SpanKindServer && ss[0].TraceID == call.TraceID && ss[0].ParentSpanID == call.SpanID,
+		"server span should continue the client span, got %+v", ss[0])
+	_assert(ss[0].Attributes["rpc.method"] == "Foo.Sum", "missing rpc.method attribute")
+}
+
+func TestTracing_ErrorRecorded(t *testing.T) {
+	spans := NewInMemoryExporter()
+	_, addr := startTestServer(t)
+	client, _ := Dial("tcp", addr, &Option{Tracer: NewTracer(spans)})
+	defer func() { _ = client.Close() }()
+	var reply int
+	_assert(client.Call("Foo.Missing", &Args{}, &reply) != nil, "expect error")
+	got := spans.Spans()
+	_assert(len(got) == 1 && got[0].Error != "" && got[0].ParentSpanID == "", "expect one root span with error, got %+v", got)
+}
+
+func TestParseTraceParent(t *testing.T) {
+	const tp = "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, err := ParseTraceParent(tp)
+	_assert(err == nil && sc.Sampled && sc.TraceParent() == tp, "round trip failed: %v %s", err, sc.TraceParent())
+	for _, bad := range []string{
+		"",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		_, err := ParseTraceParent(bad)
+		_assert(err != nil, "expect %q to be rejected", bad)
+	}
+	_, err = ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-future")
+	_assert(err == nil, "future versions should be accepted: %v", err)
+}
+
+func TestJSONFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "spans.jsonl")
+	exp, err := NewJSONFileExporter(path)
+	_assert(err == nil, "open exporter: %v", err)
+	tracer := NewTracer(exp)
+	_, span := tracer.StartSpan(context.Background(), "a", SpanKindServer)
+	span.End(nil)
+	span.End(nil) // 重复 End 不应再次导出
+	_assert(exp.Close() == nil, "close exporter")
+
+	f, _ := os.Open(path)
+	defer func() { _ = f.Close() }()
+	sc := bufio.NewScanner(f)
+	var lines []SpanData
+	for sc.Scan() {
+		var d SpanData
+		_assert(json.Unmarshal(sc.Bytes(), &d) == nil, "bad json line %s", sc.Text())
+		lines = append(lines, d)
+	}
+	_assert(len(lines) == 1 && lines[0].Name == "a", "expect exactly one exported span, got %+v", lines)
+}
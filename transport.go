@@ -0,0 +1,205 @@
+package FancyRPC
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// Unix 域套接字和进程内管道两种传输方式，与 TCP 一样配合 Server.Accept 和 NewClient 使用。
+// 另外可以通过 HTTP CONNECT 把 HTTP 连接转成 RPC 连接，便于和其他 HTTP 服务共用端口。
+
+// ListenUnix 在 path 上监听 Unix 域套接字。path 上遗留的套接字文件没有进程在监听时先删除，
+// 返回的 Listener 关闭时删除套接字文件。
+func ListenUnix(path string) (net.Listener, error) {
+	if err := removeStaleSocket(path); err != nil {
+		return nil, err
+	}
+	l, err := net.ListenUnix("unix", &net.UnixAddr{Name: path, Net: "unix"})
+	if err != nil {
+		return nil, err
+	}
+	l.SetUnlinkOnClose(true)
+	return l, nil
+}
+
+// removeStaleSocket 上一个进程没有正常退出时套接字文件会留下来，导致 Listen 失败
+func removeStaleSocket(path string) error {
+	fi, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if fi.Mode()&os.ModeSocket == 0 {
+		return fmt.Errorf("rpc: %s exists and is not a socket", path)
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		_ = conn.Close()
+		return fmt.Errorf("rpc: %s is in use", path)
+	}
+	return os.Remove(path)
+}
+
+// DialUnix 连接 path 上的 Unix 域套接字
+func DialUnix(path string, opts ...*Option) (*Client, error) {
+	return Dial("unix", path, opts...)
+}
+
+// PipeListener 进程内的 net.Listener，每次 Dial 用 net.Pipe 建立一对连接，不经过网络，适合测试和嵌入式场景
+type PipeListener struct {
+	conns chan net.Conn
+	done  chan struct{}
+	once  sync.Once
+}
+
+var _ net.Listener = (*PipeListener)(nil)
+
+func NewPipeListener() *PipeListener {
+	return &PipeListener{conns: make(chan net.Conn), done: make(chan struct{})}
+}
+
+// Accept 等待下一个 Dial，Listener 关闭后返回 net.ErrClosed
+func (l *PipeListener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case <-l.done:
+		return nil, net.ErrClosed
+	}
+}
+
+// Close 之后的 Dial 返回 net.ErrClosed，已经建立的连接不受影响
+func (l *PipeListener) Close() error {
+	l.once.Do(func() { close(l.done) })
+	return nil
+}
+
+func (l *PipeListener) Addr() net.Addr { return pipeAddr{} }
+
+// DialContext 返回客户端一侧的连接，服务端一侧交给 Accept；没有人 Accept 时阻塞到 ctx 结束
+func (l *PipeListener) DialContext(ctx context.Context) (net.Conn, error) {
+	client, server := net.Pipe()
+	select {
+	case l.conns <- server:
+		return client, nil
+	case <-l.done:
+		err := net.ErrClosed
+		_ = client.Close()
+		_ = server.Close()
+		return nil, err
+	case <-ctx.Done():
+		_ = client.Close()
+		_ = server.Close()
+		return nil, ctx.Err()
+	}
+}
+
+// Dial 与 DialContext 相同
+func (l *PipeListener) Dial() (net.Conn, error) {
+	return l.DialContext(context.Background())
+}
+
+// DialPipe 通过 l 建立进程内连接并创建 Client
+func DialPipe(l *PipeListener, opts ...*Option) (client *Client, err error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := l.Dial()
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if client == nil {
+			_ = conn.Close()
+		}
+	}()
+	return NewClient(conn, opt)
+}
+
+type pipeAddr struct{}
+
+func (pipeAddr) Network() string { return "pipe" }
+func (pipeAddr) String() string  { return "pipe" }
+
+// DefaultRPCPath ConnectHandler 默认挂载的路径，DialHTTP 向它发送 CONNECT 请求
+const DefaultRPCPath = "/_fancyrpc_"
+
+// connected CONNECT 成功时回复的状态
+const connected = "200 Connected to FancyRPC"
+
+// ServeConnect 接管 CONNECT 请求的连接并在其上提供服务，直到连接断开
+func (server *Server) ServeConnect(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodConnect {
+		w.Header().Set("Allow", http.MethodConnect)
+		http.Error(w, "rpc server: must CONNECT", http.StatusMethodNotAllowed)
+		return
+	}
+	conn, buf, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		server.logger.Log(LevelWarn, "rpc server: hijack error",
+			F(FieldRemoteAddr, r.RemoteAddr), F(FieldError, err))
+		return
+	}
+	if _, err := io.WriteString(conn, "HTTP/1.0 "+connected+"\n\n"); err != nil {
+		_ = conn.Close()
+		return
+	}
+	// 客户端在收到回复之前不会发送数据，但 buf 中可能已经读到了，不能丢掉
+	server.ServerConn(&readerConn{Conn: conn, r: buf.Reader})
+}
+
+// ConnectHandler 返回调用 ServeConnect 的 http.Handler，例如 mux.Handle(DefaultRPCPath, server.ConnectHandler())
+func (server *Server) ConnectHandler() http.Handler {
+	return http.HandlerFunc(server.ServeConnect)
+}
+
+// DialHTTP 连接 address 上的 ConnectHandler，path 为 DefaultRPCPath
+func DialHTTP(network, address string, opts ...*Option) (*Client, error) {
+	return DialHTTPPath(network, address, DefaultRPCPath, opts...)
+}
+
+// DialHTTPPath 向 address 上的 path 发送 CONNECT 请求，成功后在这条连接上创建 Client
+func DialHTTPPath(network, address, path string, opts ...*Option) (client *Client, err error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.Dial(network, address)
+	if err != nil {
+		return nil, err
+	}
+	defer func() {
+		if client == nil {
+			_ = conn.Close()
+		}
+	}()
+	if _, err = io.WriteString(conn, "CONNECT "+path+" HTTP/1.0\n\n"); err != nil {
+		return nil, err
+	}
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, &http.Request{Method: http.MethodConnect})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Status != connected {
+		return nil, errors.New("rpc client: unexpected HTTP response: " + resp.Status)
+	}
+	return NewClient(&readerConn{Conn: conn, r: r}, opt)
+}
+
+// readerConn 从 r 读取，r 包装了 Conn 并可能缓冲了数据；其余方法直接作用于 Conn
+type readerConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+func (c *readerConn) Read(p []byte) (int, error) { return c.r.Read(p) }
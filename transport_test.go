@@ -0,0 +1,90 @@
+package FancyRPC
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"FancyRPC/codec"
+)
+
+func TestUnixTransport(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+
+	path := filepath.Join(t.TempDir(), "fancy.sock")
+	// 上一个进程遗留的套接字文件
+	stale, err := net.Listen("unix", path)
+	_assert(err == nil, "listen failed: %v", err)
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	_ = stale.Close()
+
+	l, err := ListenUnix(path)
+	_assert(err == nil, "listen on stale socket failed: %v", err)
+	go server.Accept(l)
+
+	_, err = ListenUnix(path)
+	_assert(err != nil, "expect socket in use")
+
+	client, err := DialUnix(path)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "unexpected reply %d %v", reply, err)
+
+	_ = l.Close()
+	_, err = os.Stat(path)
+	_assert(os.IsNotExist(err), "expect socket file removed, got %v", err)
+}
+
+func TestPipeTransport(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	l := NewPipeListener()
+	go server.Accept(l)
+
+	client, err := DialPipe(l, &Option{CodecType: codec.MsgpackType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", &Args{Num1: 20, Num2: 22}, &reply)
+	_assert(err == nil && reply == 42, "unexpected reply %d %v", reply, err)
+
+	_ = l.Close()
+	_, err = DialPipe(l)
+	_assert(errors.Is(err, net.ErrClosed), "expect net.ErrClosed, got %v", err)
+	err = client.Call("Foo.Sum", &Args{Num1: 1, Num2: 1}, &reply)
+	_assert(err == nil && reply == 2, "existing connection should survive listener close: %v", err)
+}
+
+func TestHTTPConnectTransport(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	mux := http.NewServeMux()
+	mux.Handle(DefaultRPCPath, server.ConnectHandler())
+	ts := httptest.NewServer(mux)
+	defer ts.Close()
+	addr := strings.TrimPrefix(ts.URL, "http://")
+
+	client, err := DialHTTP("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	var reply int
+	err = client.Call("Foo.Sum", &Args{Num1: 1, Num2: 2}, &reply)
+	_assert(err == nil && reply == 3, "unexpected reply %d %v", reply, err)
+
+	_, err = DialHTTPPath("tcp", addr, "/nope")
+	_assert(err != nil && strings.Contains(err.Error(), "404"), "expect 404, got %v", err)
+	resp, err := http.Get(ts.URL + DefaultRPCPath)
+	_assert(err == nil && resp.StatusCode == http.StatusMethodNotAllowed, "expect 405 for GET, got %v %v", resp, err)
+	_ = resp.Body.Close()
+}
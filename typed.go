@@ -0,0 +1,166 @@
+package FancyRPC
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"FancyRPC/codec"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+// 泛型调用：参数和回复的类型在编译期确定，不需要预先分配 reply。
+// Method 在创建时通过反射服务检查一次类型与服务端是否兼容，之后的调用不再检查。
+
+// Invoke 调用 serviceMethod 并返回回复。R 是指针类型时分配一个新的值作为 reply，例如 protobuf 消息
+func Invoke[A, R any](ctx context.Context, cc ClientConn, serviceMethod string, args A) (R, error) {
+	var reply R
+	rv := reflect.ValueOf(&reply).Elem()
+	if rv.Kind() == reflect.Ptr {
+		rv.Set(reflect.New(rv.Type().Elem()))
+		err := cc.CallContext(ctx, serviceMethod, args, reply)
+		return reply, err
+	}
+	err := cc.CallContext(ctx, serviceMethod, args, &reply)
+	return reply, err
+}
+
+// Method 类型化的方法句柄，并发安全
+type Method[A, R any] struct {
+	cc            ClientConn
+	serviceMethod string
+}
+
+// NewMethod 通过服务端的反射服务（WithReflection）检查 A、R 与方法的参数、回复是否兼容：
+// 种类相同，结构体中本地的每个字段服务端都有；指针的层数和整数的位数不影响编解码，不做检查。
+func NewMethod[A, R any](ctx context.Context, cc ClientConn, serviceMethod string) (*Method[A, R], error) {
+	desc, err := describeRemoteMethod(ctx, cc, serviceMethod)
+	if err != nil {
+		return nil, err
+	}
+	if desc.ClientStream || desc.ServerStream {
+		return nil, fmt.Errorf("rpc client: %s is a streaming method", serviceMethod)
+	}
+	argType, replyType := reflect.TypeOf((*A)(nil)).Elem(), reflect.TypeOf((*R)(nil)).Elem()
+	if err := compatible(argType, desc.ArgType, "args"); err != nil {
+		return nil, fmt.Errorf("rpc client: %s: %w", serviceMethod, err)
+	}
+	if err := compatible(replyType, desc.ReplyType, "reply"); err != nil {
+		return nil, fmt.Errorf("rpc client: %s: %w", serviceMethod, err)
+	}
+	return &Method[A, R]{cc: cc, serviceMethod: serviceMethod}, nil
+}
+
+// Name 返回 "Service.Method"
+func (m *Method[A, R]) Name() string { return m.serviceMethod }
+
+// Call 与 Invoke 相同
+func (m *Method[A, R]) Call(ctx context.Context, args A) (R, error) {
+	return Invoke[A, R](ctx, m.cc, m.serviceMethod, args)
+}
+
+// describeRemoteMethod protobuf 编码只能传输 proto.Message，此时通过 Reflection.Schema 查询
+func describeRemoteMethod(ctx context.Context, cc ClientConn, serviceMethod string) (*MethodDesc, error) {
+	var ct string
+	switch c := cc.(type) {
+	case *Client:
+		ct = c.opt.CodecType
+	case *Pool:
+		ct = c.opt.CodecType
+	}
+	if ct != codec.ProtobufType {
+		desc := new(MethodDesc)
+		return desc, cc.CallContext(ctx, ReflectionService+".DescribeMethod", serviceMethod, desc)
+	}
+	dot := strings.LastIndex(serviceMethod, ".")
+	if dot < 0 {
+		return nil, Errorf(InvalidArgument, "rpc client: service/method request ill-formed: %s", serviceMethod)
+	}
+	schema := new(wrapperspb.BytesValue)
+	if err := cc.CallContext(ctx, ReflectionService+".Schema", wrapperspb.String(serviceMethod[:dot]), schema); err != nil {
+		return nil, err
+	}
+	var services []ServiceDesc
+	if err := json.Unmarshal(schema.GetValue(), &services); err != nil {
+		return nil, err
+	}
+	for _, s := range services {
+		for i := range s.Methods {
+			if s.Methods[i].Name == serviceMethod[dot+1:] {
+				return &s.Methods[i], nil
+			}
+		}
+	}
+	return nil, Errorf(NotFound, "rpc client: can't find method %s", serviceMethod)
+}
+
+// kindClasses 编解码时可以互相转换的种类，键是 reflect.Kind 的名字
+var kindClasses = map[string]string{
+	"int": "int", "int8": "int", "int16": "int", "int32": "int", "int64": "int",
+	"uint": "uint", "uint8": "uint", "uint16": "uint", "uint32": "uint", "uint64": "uint", "uintptr": "uint",
+	"float32": "float", "float64": "float",
+	"complex64": "complex", "complex128": "complex",
+	"slice": "list", "array": "list",
+}
+
+func kindClass(kind string) string {
+	if class, ok := kindClasses[kind]; ok {
+		return class
+	}
+	return kind
+}
+
+// compatible 检查本地类型 t 能否与服务端描述的 remote 互相编解码，path 用于错误信息
+func compatible(t reflect.Type, remote *TypeDesc, path string) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	for remote.Kind == reflect.Ptr.String() && remote.Elem != nil {
+		remote = remote.Elem
+	}
+	if t.String() == remote.Name || t.Kind() == reflect.Interface || remote.Kind == reflect.Interface.String() {
+		return nil
+	}
+	local := kindClass(t.Kind().String())
+	if local != kindClass(remote.Kind) {
+		return fmt.Errorf("%s is %s locally but %s (%s) on the server", path, t, remote.Name, remote.Kind)
+	}
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		if remote.Elem != nil {
+			return compatible(t.Elem(), remote.Elem, path+"[]")
+		}
+	case reflect.Map:
+		if remote.Key != nil && remote.Elem != nil {
+			if err := compatible(t.Key(), remote.Key, path+"[key]"); err != nil {
+				return err
+			}
+			return compatible(t.Elem(), remote.Elem, path+"[]")
+		}
+	case reflect.Struct:
+		if remote.Recursive {
+			return nil
+		}
+		fields := make(map[string]*TypeDesc, len(remote.Fields))
+		for _, f := range remote.Fields {
+			fields[f.Name] = f.Type
+		}
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if !f.IsExported() {
+				continue
+			}
+			ft, ok := fields[f.Name]
+			if !ok {
+				return fmt.Errorf("%s.%s does not exist on the server type %s", path, f.Name, remote.Name)
+			}
+			if err := compatible(f.Type, ft, path+"."+f.Name); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,88 @@
+package FancyRPC
+
+import (
+	"context"
+	"net"
+	"strings"
+	"testing"
+
+	"FancyRPC/codec"
+
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestInvoke(t *testing.T) {
+	addr := startReflectionServer(t)
+	client, err := Dial("tcp", addr, &Option{CodecType: codec.MsgpackType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	sum, err := Invoke[Args, int](ctx, client, "Foo.Sum", Args{Num1: 1, Num2: 2})
+	_assert(err == nil && sum == 3, "unexpected sum %d %v", sum, err)
+	// R 是指针时不需要预先分配
+	ev, err := Invoke[Event, *Event](ctx, client, "Tree.Echo", Event{Args: Args{Num1: 7}})
+	_assert(err == nil && ev != nil && ev.Num1 == 7, "unexpected event %+v %v", ev, err)
+
+	pool, err := NewPool("tcp", addr, PoolConfig{})
+	_assert(err == nil, "new pool failed: %v", err)
+	defer func() { _ = pool.Close() }()
+	sum, err = Invoke[*Args, int](ctx, pool, "Foo.Sum", &Args{Num1: 20, Num2: 22})
+	_assert(err == nil && sum == 42, "unexpected sum %d %v", sum, err)
+}
+
+func TestNewMethod(t *testing.T) {
+	addr := startReflectionServer(t)
+	client, err := Dial("tcp", addr)
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	ctx := context.Background()
+
+	sum, err := NewMethod[Args, int](ctx, client, "Foo.Sum")
+	_assert(err == nil && sum.Name() == "Foo.Sum", "new method failed: %v", err)
+	reply, err := sum.Call(ctx, Args{Num1: 1, Num2: 2})
+	_assert(err == nil && reply == 3, "unexpected reply %d %v", reply, err)
+
+	// 结构相同的类型，指针层数和整数位数不同也可以
+	type localArgs struct{ Num1, Num2 int64 }
+	sum2, err := NewMethod[*localArgs, int32](ctx, client, "Foo.Sum")
+	_assert(err == nil, "compatible types rejected: %v", err)
+	reply2, err := sum2.Call(ctx, &localArgs{Num1: 2, Num2: 3})
+	_assert(err == nil && reply2 == 5, "unexpected reply %d %v", reply2, err)
+
+	cases := []struct {
+		method func() error
+		err    string
+	}{
+		{func() error { _, err := NewMethod[Args, string](ctx, client, "Foo.Sum"); return err },
+			"reply is string locally but int (int) on the server"},
+		{func() error { _, err := NewMethod[struct{ Num1, Num3 int }, int](ctx, client, "Foo.Sum"); return err },
+			"args.Num3 does not exist on the server type FancyRPC.Args"},
+		{func() error { _, err := NewMethod[map[string][]string, Event](ctx, client, "Tree.Echo"); return err },
+			"args is map[string][]string locally but FancyRPC.Event (struct) on the server"},
+		{func() error { _, err := NewMethod[int, Row](ctx, client, "Rows.List"); return err },
+			"Rows.List is a streaming method"},
+		{func() error { _, err := NewMethod[Args, int](ctx, client, "Foo.Nope"); return err },
+			"can't find method Nope"},
+	}
+	for _, c := range cases {
+		err := c.method()
+		_assert(err != nil && strings.Contains(err.Error(), c.err), "expect error %q, got %v", c.err, err)
+	}
+}
+
+func TestNewMethod_Protobuf(t *testing.T) {
+	server := NewServer(WithReflection())
+	_assert(server.Register(Echo{}) == nil, "register Echo failed")
+	l, _ := net.Listen("tcp", "127.0.0.1:0")
+	defer func() { _ = l.Close() }()
+	go server.Accept(l)
+
+	client, err := Dial("tcp", l.Addr().String(), &Option{CodecType: codec.ProtobufType})
+	_assert(err == nil, "dial failed: %v", err)
+	defer func() { _ = client.Close() }()
+	upper, err := NewMethod[*wrapperspb.StringValue, *wrapperspb.StringValue](context.Background(), client, "Echo.Upper")
+	_assert(err == nil, "new method failed: %v", err)
+	reply, err := upper.Call(context.Background(), wrapperspb.String("fancy"))
+	_assert(err == nil && reply.GetValue() == "FANCY", "unexpected reply %q %v", reply.GetValue(), err)
+}
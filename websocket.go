@@ -0,0 +1,132 @@
+package FancyRPC
+
+import (
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// WebSocket 传输：HTTP 升级之后，连接上的字节流按二进制消息收发，每次 Write 是一条消息，
+// 读取时把连续的消息拼成字节流，因此 Option 握手和各种 Codec 都不需要改动。
+// 浏览器等无法建立 TCP 连接的客户端可以通过它访问同样注册的服务。
+
+// WithWebSocketOriginCheck 设置升级请求的 Origin 检查，默认只允许与 Host 同源的请求
+func WithWebSocketOriginCheck(check func(r *http.Request) bool) ServerOption {
+	return func(server *Server) {
+		server.checkOrigin = check
+	}
+}
+
+// ServeWebSocket 把请求升级为 WebSocket 连接并在其上提供服务，直到连接断开
+func (server *Server) ServeWebSocket(w http.ResponseWriter, r *http.Request) {
+	upgrader := websocket.Upgrader{CheckOrigin: server.checkOrigin}
+	ws, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade 已经回复了错误
+		server.logger.Log(LevelWarn, "rpc server: websocket upgrade error",
+			F(FieldRemoteAddr, r.RemoteAddr), F(FieldError, err))
+		return
+	}
+	server.ServerConn(newWSConn(ws))
+}
+
+// WebSocketHandler 返回调用 ServeWebSocket 的 http.Handler，例如 mux.Handle("/rpc", server.WebSocketHandler())
+func (server *Server) WebSocketHandler() http.Handler {
+	return http.HandlerFunc(server.ServeWebSocket)
+}
+
+// DialWebSocket 连接 ws:// 或 wss:// 地址上的 WebSocketHandler
+func DialWebSocket(url string, opts ...*Option) (client *Client, err error) {
+	opt, err := parseOptions(opts...)
+	if err != nil {
+		return nil, err
+	}
+	ws, resp, err := websocket.DefaultDialer.Dial(url, nil)
+	if resp != nil && resp.Body != nil {
+		_ = resp.Body.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn := newWSConn(ws)
+	defer func() {
+		if client == nil {
+			_ = conn.Close()
+		}
+	}()
+	return NewClient(conn, opt)
+}
+
+// wsConn 把 WebSocket 连接适配成 net.Conn
+type wsConn struct {
+	ws      *websocket.Conn
+	r       io.Reader // 当前正在读的消息
+	writing sync.Mutex
+}
+
+var _ net.Conn = (*wsConn)(nil)
+
+func newWSConn(ws *websocket.Conn) *wsConn {
+	return &wsConn{ws: ws}
+}
+
+var errWSTextMessage = errors.New("rpc: unexpected websocket text message")
+
+func (c *wsConn) Read(p []byte) (int, error) {
+	for {
+		if c.r == nil {
+			typ, r, err := c.ws.NextReader()
+			if err != nil {
+				if websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			if typ != websocket.BinaryMessage {
+				return 0, errWSTextMessage
+			}
+			c.r = r
+		}
+		n, err := c.r.Read(p)
+		if err == io.EOF {
+			// 这条消息读完了，接着读下一条
+			c.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+func (c *wsConn) Write(p []byte) (int, error) {
+	c.writing.Lock()
+	defer c.writing.Unlock()
+	if err := c.ws.WriteMessage(websocket.BinaryMessage, p); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (c *wsConn) Close() error {
+	return c.ws.Close()
+}
+
+func (c *wsConn) LocalAddr() net.Addr  { return c.ws.LocalAddr() }
+func (c *wsConn) RemoteAddr() net.Addr { return c.ws.RemoteAddr() }
+
+func (c *wsConn) SetDeadline(t time.Time) error {
+	if err := c.ws.SetReadDeadline(t); err != nil {
+		return err
+	}
+	return c.ws.SetWriteDeadline(t)
+}
+
+func (c *wsConn) SetReadDeadline(t time.Time) error  { return c.ws.SetReadDeadline(t) }
+func (c *wsConn) SetWriteDeadline(t time.Time) error { return c.ws.SetWriteDeadline(t) }
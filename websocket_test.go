@@ -0,0 +1,52 @@
+package FancyRPC
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"FancyRPC/codec"
+)
+
+func TestWebSocketTransport(t *testing.T) {
+	server := NewServer()
+	var foo Foo
+	_assert(server.Register(&foo) == nil, "register Foo failed")
+	_assert(server.Register(Blob{}) == nil, "register Blob failed")
+	ts := httptest.NewServer(server.WebSocketHandler())
+	defer ts.Close()
+	url := "ws" + strings.TrimPrefix(ts.URL, "http")
+
+	for _, ct := range []string{codec.GobType, codec.MsgpackType} {
+		client, err := DialWebSocket(url, &Option{CodecType: ct})
+		_assert(err == nil, "%s: dial failed: %v", ct, err)
+		var reply int
+		err = client.Call("Foo.Sum", &Args{Num1: 20, Num2: 22}, &reply)
+		_assert(err == nil && reply == 42, "%s: unexpected reply %d %v", ct, reply, err)
+		// 大于一条消息缓冲区的 body 跨多条消息传输
+		var s string
+		err = client.Call("Blob.Repeat", RepeatArgs{S: "fancy", N: 10000}, &s)
+		_assert(err == nil && s == strings.Repeat("fancy", 10000), "%s: unexpected reply len %d %v", ct, len(s), err)
+		_ = client.Close()
+	}
+}
+
+func TestWebSocketTransport_Origin(t *testing.T) {
+	server := NewServer(WithWebSocketOriginCheck(func(r *http.Request) bool {
+		return r.Header.Get("Origin") == ""
+	}))
+	ts := httptest.NewServer(server.WebSocketHandler())
+	defer ts.Close()
+
+	req, _ := http.NewRequest(http.MethodGet, ts.URL, nil)
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	req.Header.Set("Origin", "https://evil.example")
+	resp, err := http.DefaultClient.Do(req)
+	_assert(err == nil, "request failed: %v", err)
+	_ = resp.Body.Close()
+	_assert(resp.StatusCode == http.StatusForbidden, "expect 403, got %d", resp.StatusCode)
+}